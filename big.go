@@ -0,0 +1,54 @@
+// Copyright 2023 The go-fuzz-headers Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gofuzzheaders
+
+import "math/big"
+
+// bigNumberFuncs are the custom functions registered by
+// WithBigNumberSupport.
+var bigNumberFuncs = []interface{}{fuzzBigInt, fuzzBigFloat}
+
+// fuzzBigInt sets b to a big-endian magnitude read with GetBytes and a sign.
+func fuzzBigInt(b *big.Int, c Continue) error {
+	abs, err := c.Decoder().GetBytes()
+	if err != nil {
+		return err
+	}
+	neg, err := c.Decoder().GetBool()
+	if err != nil {
+		return err
+	}
+	b.SetBytes(abs)
+	if neg {
+		b.Neg(b)
+	}
+	return nil
+}
+
+// fuzzBigFloat sets b to a big.Int mantissa scaled by a power of two in
+// [-128, 127].
+func fuzzBigFloat(b *big.Float, c Continue) error {
+	var mant big.Int
+	if err := fuzzBigInt(&mant, c); err != nil {
+		return err
+	}
+	exp, err := c.Decoder().GetByte()
+	if err != nil {
+		return err
+	}
+	b.SetInt(&mant)
+	b.SetMantExp(b, int(int8(exp)))
+	return nil
+}
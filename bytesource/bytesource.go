@@ -18,14 +18,76 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"math"
+	"math/bits"
+	"math/rand"
+	"time"
+	"unicode/utf8"
 )
 
+// Source is what generated values are decoded from. ByteSource is the
+// default implementation.
+type Source interface {
+	GetInt() (int, error)
+	GetPositiveInt() (int, error)
+	GetIntInRange(min, max int) (int, error)
+	GetUint64InRange(min, max uint64) (uint64, error)
+	GetChoiceIndex(n int) (int, error)
+	GetByte() (byte, error)
+	GetNBytes(n int) ([]byte, error)
+	GetExactBytes(n int) ([]byte, error)
+	GetUint16() (uint16, error)
+	GetUint16With(order binary.ByteOrder) (uint16, error)
+	GetUint32() (uint32, error)
+	GetUint32With(order binary.ByteOrder) (uint32, error)
+	GetUint64() (uint64, error)
+	GetUint64With(order binary.ByteOrder) (uint64, error)
+	GetLengthPrefixed(maxLen int) ([]byte, error)
+	GetBytes() ([]byte, error)
+	GetLength() (uint32, error)
+	GetString() (string, error)
+	GetUTF8String() (string, error)
+	GetBool() (bool, error)
+	GetStringFrom(possibleChars string, length int) (string, error)
+	GetRune() ([]rune, error)
+	GetFloat32() (float32, error)
+	GetFloat32With(order binary.ByteOrder) (float32, error)
+	GetFloat64() (float64, error)
+	GetFloat64With(order binary.ByteOrder) (float64, error)
+	GetTime() (time.Time, error)
+	// Position returns the offset of the next byte to be read.
+	Position() uint32
+	// Remaining returns the number of bytes left to read, math.MaxUint32
+	// if unknown.
+	Remaining() uint32
+}
+
+var _ Source = (*ByteSource)(nil)
+
 type ByteSource struct {
 	data         []byte
 	dataTotal    uint32
 	position     uint32
 	maxStringLen uint32
+	fallback     *rand.Rand
+	// owned is set once data is no longer the caller's buffer, so that it
+	// can be appended to in place.
+	owned bool
+	// timeMin and timeMax bound GetTime, in Unix seconds or nanoseconds
+	// depending on timeNanos.
+	timeMin   int64
+	timeMax   int64
+	timeNanos bool
+	// order is the byte order of multi-byte values, read from the source
+	// for each value when nil.
+	order binary.ByteOrder
+	// reader is read lazily into data, until it is exhausted. Bytes read
+	// from it are dropped from data once consumed, discarded counts them.
+	reader    io.Reader
+	discarded uint32
+	// readErr is the error that ended the reader, unless it is io.EOF.
+	readErr error
 }
 
 var (
@@ -39,10 +101,203 @@ func New(input []byte, maxStringLen uint32) *ByteSource {
 		dataTotal:    uint32(len(input)),
 		position:     0,
 		maxStringLen: maxStringLen,
+		order:        binary.LittleEndian,
+		timeMax:      defaultTimeMax,
 	}
 	return s
 }
 
+// NewFromReader returns a new ByteSource reading lazily from r, in chunks, as
+// values are decoded. Decoding the same bytes from a reader or a slice
+// gives the same values, and getters fail with ErrNotEnoughBytes once r
+// returns an error, which Err reports unless it is io.EOF. Consumed bytes
+// are dropped, so that a long stream is decoded in bounded memory.
+func NewFromReader(r io.Reader, maxStringLen uint32) *ByteSource {
+	return &ByteSource{
+		maxStringLen: maxStringLen,
+		reader:       r,
+		owned:        true,
+		order:        binary.LittleEndian,
+		timeMax:      defaultTimeMax,
+	}
+}
+
+// SetFallbackRandom makes the source return bytes from a math/rand stream
+// seeded with seed once every input byte has been consumed, instead of
+// failing with ErrNotEnoughBytes. The input bytes are always read first, so
+// the same input and seed always decode to the same values.
+func (f *ByteSource) SetFallbackRandom(seed int64) {
+	f.fallback = rand.New(rand.NewSource(seed))
+}
+
+// readChunkSize is the number of bytes requested from a reader at once.
+const readChunkSize = 4096
+
+// SetMaxStringLen sets the maximum length of byte slices and strings.
+func (f *ByteSource) SetMaxStringLen(n uint32) {
+	f.maxStringLen = n
+}
+
+// SetByteOrder sets the byte order of multi-byte values, little endian by
+// default. With a nil order, each value is followed by a byte picking its
+// byte order, as in earlier versions.
+func (f *ByteSource) SetByteOrder(order binary.ByteOrder) {
+	f.order = order
+}
+
+// ByteOrder returns the byte order of multi-byte values, nil if it is read
+// from the source for each value.
+func (f *ByteSource) ByteOrder() binary.ByteOrder {
+	return f.order
+}
+
+// defaultTimeMax bounds GetTime to before the year 2100 by default.
+const defaultTimeMax = 4102444800
+
+// SetTimeWindow makes GetTime return times in [min, max), with a second
+// precision, or a nanosecond one after SetTimeNanos(true). The default
+// window is from the Unix epoch to the year 2100. It panics if min is not
+// before max.
+func (f *ByteSource) SetTimeWindow(min, max time.Time) {
+	if !min.Before(max) {
+		panic(fmt.Sprintf("invalid time window [%s, %s)", min, max))
+	}
+	f.timeMin, f.timeMax = min.Unix(), max.Unix()
+	if f.timeNanos {
+		f.timeMin, f.timeMax = min.UnixNano(), max.UnixNano()
+	}
+}
+
+// SetTimeNanos sets whether GetTime generates nanoseconds or whole seconds.
+// The time window is kept.
+func (f *ByteSource) SetTimeNanos(nanos bool) {
+	if nanos == f.timeNanos {
+		return
+	}
+	min, max := f.timeWindow()
+	f.timeNanos = nanos
+	f.SetTimeWindow(min, max)
+}
+
+func (f *ByteSource) timeWindow() (time.Time, time.Time) {
+	if f.timeNanos {
+		return time.Unix(0, f.timeMin), time.Unix(0, f.timeMax)
+	}
+	return time.Unix(f.timeMin, 0), time.Unix(f.timeMax, 0)
+}
+
+// GetTime reads a uint64 and returns it as a UTC time within the time
+// window.
+func (f *ByteSource) GetTime() (time.Time, error) {
+	u, err := f.GetUint64()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to create time: %w", err)
+	}
+	v := f.timeMin + int64(u%uint64(f.timeMax-f.timeMin))
+	if f.timeNanos {
+		return time.Unix(0, v).UTC(), nil
+	}
+	return time.Unix(v, 0).UTC(), nil
+}
+
+// byteOrder returns the fixed byte order, or reads one.
+func (f *ByteSource) byteOrder() (binary.ByteOrder, error) {
+	if f.order != nil {
+		return f.order, nil
+	}
+	littleEndian, err := f.GetBool()
+	if err != nil {
+		return nil, err
+	}
+	if littleEndian {
+		return binary.LittleEndian, nil
+	}
+	return binary.BigEndian, nil
+}
+
+// extend makes sure n bytes are available past the current position when
+// reading from a reader or a fallback random stream is set.
+func (f *ByteSource) extend(n uint32) {
+	if f.reader != nil {
+		f.compact()
+		var chunk [readChunkSize]byte
+		for f.dataTotal-f.position < n {
+			read, err := f.reader.Read(chunk[:])
+			// data is never the caller's buffer when reading from a
+			// reader.
+			f.data = append(f.data, chunk[:read]...)
+			f.dataTotal = uint32(len(f.data))
+			if err != nil {
+				if err != io.EOF {
+					f.readErr = err
+				}
+				f.reader = nil
+				break
+			}
+		}
+	}
+	if f.fallback == nil || f.dataTotal-f.position >= n {
+		return
+	}
+	missing := make([]byte, n-(f.dataTotal-f.position))
+	f.fallback.Read(missing)
+	if !f.owned {
+		// Never append into the caller's buffer.
+		f.data = f.data[:f.dataTotal:f.dataTotal]
+		f.owned = true
+	}
+	f.data = append(f.data, missing...)
+	f.dataTotal = uint32(len(f.data))
+}
+
+// compact drops the bytes read from a reader that are more than a chunk
+// behind the position, once they make up half of the data: getters never
+// rewind that far. The rest is copied to a new buffer, so that slices
+// returned earlier stay valid.
+func (f *ByteSource) compact() {
+	if f.position <= readChunkSize {
+		return
+	}
+	drop := f.position - readChunkSize
+	if drop < f.dataTotal/2 {
+		return
+	}
+	f.data = append([]byte(nil), f.data[drop:f.dataTotal]...)
+	f.discarded += drop
+	f.position -= drop
+	f.dataTotal -= drop
+}
+
+// Err returns the error that ended reading from a reader, or nil if it
+// ended with io.EOF or has not ended. Getters fail with ErrNotEnoughBytes
+// once the reader has ended, whatever the error.
+func (f *ByteSource) Err() error {
+	return f.readErr
+}
+
+// Position returns the offset of the next byte to be read.
+func (f *ByteSource) Position() uint32 {
+	return f.discarded + f.position
+}
+
+// Unconsumed returns the input bytes that have not been read yet. When
+// reading from a reader, only the bytes already read from it are returned.
+// Bytes generated by the fallback random stream are included once they have
+// been generated. The returned slice shares memory with the input.
+func (f *ByteSource) Unconsumed() []byte {
+	return f.data[f.position:f.dataTotal]
+}
+
+// Remaining returns the number of input bytes left to read, or
+// math.MaxUint32 when a fallback random stream is set or when reading from
+// a reader that has not been exhausted yet.
+func (f *ByteSource) Remaining() uint32 {
+	if f.fallback != nil || f.reader != nil {
+		return math.MaxUint32
+	}
+	return f.dataTotal - f.position
+}
+
 func (f *ByteSource) GetInt() (int, error) {
 	returnByte, err := f.GetByte()
 	if err != nil {
@@ -51,7 +306,67 @@ func (f *ByteSource) GetInt() (int, error) {
 	return int(returnByte), nil
 }
 
+// GetPositiveInt returns an int in [0, 255] read from a single byte, for
+// lengths and indices. It currently decodes like GetInt, but unlike GetInt
+// it will keep returning non-negative values if GetInt covers the signed
+// range.
+func (f *ByteSource) GetPositiveInt() (int, error) {
+	return f.GetInt()
+}
+
+// GetIntInRange returns an int in [min, max]. It returns an error if min is
+// greater than max.
+func (f *ByteSource) GetIntInRange(min, max int) (int, error) {
+	if min > max {
+		return 0, fmt.Errorf("failed to create int: invalid range [%d, %d]", min, max)
+	}
+	v, err := f.GetUint64InRange(0, uint64(max)-uint64(min))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create int: %w", err)
+	}
+	return min + int(v), nil
+}
+
+// GetUint64InRange returns a uint64 in [min, max]. It reads only as many
+// bytes as are needed to cover the range, so a single value range consumes
+// nothing. It returns an error if min is greater than max.
+func (f *ByteSource) GetUint64InRange(min, max uint64) (uint64, error) {
+	if min > max {
+		return 0, fmt.Errorf("failed to create uint64: invalid range [%d, %d]", min, max)
+	}
+	span := max - min
+	if span == 0 {
+		return min, nil
+	}
+	b, err := f.GetNBytes((bits.Len64(span) + 7) / 8)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create uint64: %w", err)
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	if span != math.MaxUint64 {
+		v %= span + 1
+	}
+	return min + v, nil
+}
+
+// GetChoiceIndex returns an index in [0, n). A single choice consumes no
+// bytes.
+func (f *ByteSource) GetChoiceIndex(n int) (int, error) {
+	if n <= 0 {
+		return 0, fmt.Errorf("failed to create choice index: no choices")
+	}
+	i, err := f.GetUint64InRange(0, uint64(n-1))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create choice index: %w", err)
+	}
+	return int(i), nil
+}
+
 func (f *ByteSource) GetByte() (byte, error) {
+	f.extend(1)
 	if f.position >= f.dataTotal {
 		return 0x00, fmt.Errorf("failed to get byte: %w", ErrNotEnoughBytes)
 	}
@@ -61,89 +376,206 @@ func (f *ByteSource) GetByte() (byte, error) {
 }
 
 func (f *ByteSource) GetNBytes(numberOfBytes int) ([]byte, error) {
-	if f.position >= f.dataTotal {
-		return nil, fmt.Errorf("failed to get bytes: %w", ErrNotEnoughBytes)
+	// Check up front so a short read leaves the position untouched.
+	return f.GetExactBytes(numberOfBytes)
+}
+
+// GetExactBytes returns exactly n bytes, or ErrNotEnoughBytes without
+// consuming anything if fewer are left.
+func (f *ByteSource) GetExactBytes(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("failed to get bytes: invalid length %d", n)
 	}
-	returnBytes := make([]byte, 0, numberOfBytes)
-	for i := 0; i < numberOfBytes; i++ {
-		newByte, err := f.GetByte()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get byte: %w", err)
-		}
-		returnBytes = append(returnBytes, newByte)
+	f.extend(uint32(n))
+	if uint64(n) > uint64(f.dataTotal-f.position) {
+		return nil, fmt.Errorf("failed to get %d bytes: %w", n, ErrNotEnoughBytes)
 	}
-	return returnBytes, nil
+	b := make([]byte, n)
+	copy(b, f.data[f.position:])
+	f.position += uint32(n)
+	return b, nil
 }
 
 func (f *ByteSource) GetUint16() (uint16, error) {
-	u16, err := f.GetNBytes(2)
+	b, err := f.GetNBytes(2)
 	if err != nil {
 		return 0, fmt.Errorf("failed to create uint16: %w", err)
 	}
-	littleEndian, err := f.GetBool()
+	order, err := f.byteOrder()
 	if err != nil {
 		return 0, fmt.Errorf("failed to create uint16: %w", err)
 	}
-	if littleEndian {
-		return binary.LittleEndian.Uint16(u16), nil
+	return order.Uint16(b), nil
+}
+
+// GetUint16With reads a uint16 in the given byte order.
+func (f *ByteSource) GetUint16With(order binary.ByteOrder) (uint16, error) {
+	b, err := f.GetNBytes(2)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create uint16: %w", err)
 	}
-	return binary.BigEndian.Uint16(u16), nil
+	return order.Uint16(b), nil
 }
 
 func (f *ByteSource) GetUint32() (uint32, error) {
-	i, err := f.GetInt()
+	b, err := f.GetNBytes(4)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create uint32: %w", err)
+	}
+	order, err := f.byteOrder()
 	if err != nil {
 		return 0, fmt.Errorf("failed to create uint32: %w", err)
 	}
-	return uint32(i), nil
+	return order.Uint32(b), nil
+}
+
+// GetUint32With reads a uint32 in the given byte order.
+func (f *ByteSource) GetUint32With(order binary.ByteOrder) (uint32, error) {
+	b, err := f.GetNBytes(4)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create uint32: %w", err)
+	}
+	return order.Uint32(b), nil
+}
+
+// lengthEscape is the length prefix byte announcing a four byte length.
+const lengthEscape = 0xff
+
+// GetLength reads a length encoded like the prefix of GetLengthPrefixed,
+// without clamping it. It consumes nothing on error.
+func (f *ByteSource) GetLength() (uint32, error) {
+	b, err := f.GetByte()
+	if err != nil {
+		return 0, fmt.Errorf("failed to create length: %w", err)
+	}
+	if b != lengthEscape {
+		return uint32(b), nil
+	}
+	length, err := f.GetUint32With(binary.LittleEndian)
+	if err != nil {
+		f.position--
+		return 0, fmt.Errorf("failed to create length: %w", err)
+	}
+	return length, nil
+}
+
+// clampLength clamps a length to max and to the number of bytes left, see
+// GetLengthPrefixed.
+func (f *ByteSource) clampLength(length, max uint32) uint32 {
+	if length > max {
+		length = max
+	}
+	if remaining := f.Remaining(); length > remaining {
+		length = remaining
+	}
+	return length
 }
 
 func (f *ByteSource) GetUint64() (uint64, error) {
-	u64, err := f.GetNBytes(8)
+	b, err := f.GetNBytes(8)
 	if err != nil {
 		return 0, fmt.Errorf("failed to create uint64: %w", err)
 	}
-	littleEndian, err := f.GetBool()
+	order, err := f.byteOrder()
 	if err != nil {
 		return 0, fmt.Errorf("failed to create uint64: %w", err)
 	}
-	if littleEndian {
-		return binary.LittleEndian.Uint64(u64), nil
-	}
-	return binary.BigEndian.Uint64(u64), nil
+	return order.Uint64(b), nil
 }
 
-func (f *ByteSource) GetBytes() ([]byte, error) {
-	length, err := f.GetUint32()
+// GetUint64With reads a uint64 in the given byte order.
+func (f *ByteSource) GetUint64With(order binary.ByteOrder) (uint64, error) {
+	b, err := f.GetNBytes(8)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create byte array: %w", err)
+		return 0, fmt.Errorf("failed to create uint64: %w", err)
+	}
+	return order.Uint64(b), nil
+}
+
+// GetLengthPrefixed reads a length followed by at most that many bytes. The
+// length is a single byte from 0 to 254, e.g. 0x02 'h' 'i' reads "hi", or
+// the byte 0xff followed by the length as a little endian uint32. The
+// returned slice aliases the input data, a zero length yields an empty,
+// non-nil slice.
+//
+// Every length is clamped to maxLen and to the number of bytes left, e.g.
+// 0x05 'h' 'i' also reads "hi", so that any input, including a fallback
+// random stream, yields a value rather than an error. It only returns an
+// error wrapping ErrNotEnoughBytes if the input ends before the length, in
+// which case nothing is consumed.
+func (f *ByteSource) GetLengthPrefixed(maxLen int) ([]byte, error) {
+	if maxLen < 0 {
+		return nil, fmt.Errorf("failed to get length prefixed bytes: invalid maximum length %d", maxLen)
 	}
-	if length == 0 {
-		return []byte{}, nil
+	length, err := f.GetLength()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get length prefixed bytes: %w", err)
 	}
-	byteBegin := f.position
-	if byteBegin >= f.dataTotal {
-		return nil, fmt.Errorf("failed to create byte slice: byte begin past data total: %w", ErrNotEnoughBytes)
+	max := uint64(maxLen)
+	if max > math.MaxUint32 {
+		max = math.MaxUint32
 	}
-	if byteBegin+length >= f.dataTotal {
-		return nil, fmt.Errorf("failed to create byte slice: byte end past data total: %w", ErrNotEnoughBytes)
+	length = f.clampLength(length, uint32(max))
+	f.extend(length)
+	// A reader may end before the length.
+	if left := f.dataTotal - f.position; length > left {
+		length = left
 	}
-	if byteBegin+length > f.maxStringLen {
-		return nil, fmt.Errorf("created too large a string: %w", ErrNotEnoughBytes)
+	b := f.data[f.position : f.position+length]
+	f.position += length
+	return b, nil
+}
+
+// GetBytes reads bytes with GetLengthPrefixed, up to the maximum string
+// length.
+func (f *ByteSource) GetBytes() ([]byte, error) {
+	b, err := f.GetLengthPrefixed(int(f.maxStringLen))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create byte array: %w", err)
 	}
-	f.position = byteBegin + length
-	return f.data[byteBegin:f.position], nil
+	return b, nil
 }
 
 func (f *ByteSource) GetString() (string, error) {
 	b, err := f.GetBytes()
 	if err != nil {
-		return "nil", fmt.Errorf("failed to create string: %w", err)
+		return "", fmt.Errorf("failed to create string: %w", err)
 	}
 
 	return string(b), nil
 }
 
+// GetUTF8String reads a length, encoded and clamped to the maximum string
+// length like the length of GetLengthPrefixed, followed by up to that many
+// runes, fewer if the input ends first.
+// Valid UTF-8 sequences in the input are decoded as is, any other byte is
+// mapped to the rune of the same value, so the returned string is always
+// valid UTF-8.
+func (f *ByteSource) GetUTF8String() (string, error) {
+	length, err := f.GetLength()
+	if err != nil {
+		return "", fmt.Errorf("failed to create utf8 string: %w", err)
+	}
+	length = f.clampLength(length, f.maxStringLen)
+	// The length is not trusted to preallocate, every rune takes at least
+	// one byte of input.
+	var runes []rune
+	for i := uint32(0); i < length; i++ {
+		f.extend(utf8.UTFMax)
+		if f.position >= f.dataTotal {
+			break
+		}
+		r, size := utf8.DecodeRune(f.data[f.position:f.dataTotal])
+		if r == utf8.RuneError && size <= 1 {
+			r = rune(f.data[f.position])
+			size = 1
+		}
+		f.position += uint32(size)
+		runes = append(runes, r)
+	}
+	return string(runes), nil
+}
+
 func (f *ByteSource) GetBool() (bool, error) {
 	i, err := f.GetInt()
 	if err != nil {
@@ -154,14 +586,25 @@ func (f *ByteSource) GetBool() (bool, error) {
 
 // GetStringFrom returns a string that can only consist of characters
 // included in possibleChars. It returns an error if the created string
-// does not have the specified length.
+// does not have the specified length, or if length is negative or greater
+// than the maximum string length.
 func (f *ByteSource) GetStringFrom(possibleChars string, length int) (string, error) {
+	if length < 0 {
+		return "", fmt.Errorf("failed to create a string: negative length %d", length)
+	}
+	if possibleChars == "" {
+		return "", fmt.Errorf("failed to create a string: no possible characters")
+	}
+	if uint64(length) > uint64(f.maxStringLen) {
+		return "", fmt.Errorf("created too large a string: %w", ErrNotEnoughBytes)
+	}
+	f.extend(uint32(length))
 	if (f.dataTotal - f.position) < uint32(length) {
 		return "", fmt.Errorf("failed to create a string: %w", ErrNotEnoughBytes)
 	}
 	output := make([]byte, 0, length)
 	for i := 0; i < length; i++ {
-		charIndex, err := f.GetInt()
+		charIndex, err := f.GetPositiveInt()
 		if err != nil {
 			return string(output), fmt.Errorf("failed to create a string: %w", err)
 		}
@@ -173,41 +616,67 @@ func (f *ByteSource) GetStringFrom(possibleChars string, length int) (string, er
 func (f *ByteSource) GetRune() ([]rune, error) {
 	stringToConvert, err := f.GetString()
 	if err != nil {
-		return []rune("nil"), fmt.Errorf("failed to create rune: %w", err)
+		return nil, fmt.Errorf("failed to create rune: %w", err)
 	}
 	return []rune(stringToConvert), nil
 }
 
 func (f *ByteSource) GetFloat32() (float32, error) {
-	u32, err := f.GetNBytes(4)
+	b, err := f.GetNBytes(4)
 	if err != nil {
 		return 0, fmt.Errorf("failed to create float32: %w", err)
 	}
-	littleEndian, err := f.GetBool()
+	order, err := f.byteOrder()
 	if err != nil {
 		return 0, fmt.Errorf("failed to create float32: %w", err)
 	}
-	if littleEndian {
-		u32LE := binary.LittleEndian.Uint32(u32)
-		return math.Float32frombits(u32LE), nil
+	return math.Float32frombits(order.Uint32(b)), nil
+}
+
+// GetFloat32With reads a float32 in the given byte order.
+func (f *ByteSource) GetFloat32With(order binary.ByteOrder) (float32, error) {
+	b, err := f.GetNBytes(4)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create float32: %w", err)
 	}
-	u32BE := binary.BigEndian.Uint32(u32)
-	return math.Float32frombits(u32BE), nil
+	return math.Float32frombits(order.Uint32(b)), nil
 }
 
 func (f *ByteSource) GetFloat64() (float64, error) {
-	u64, err := f.GetNBytes(8)
+	b, err := f.GetNBytes(8)
 	if err != nil {
 		return 0, fmt.Errorf("failed to create float64: %w", err)
 	}
-	littleEndian, err := f.GetBool()
+	order, err := f.byteOrder()
 	if err != nil {
 		return 0, fmt.Errorf("failed to create float64: %w", err)
 	}
-	if littleEndian {
-		u64LE := binary.LittleEndian.Uint64(u64)
-		return math.Float64frombits(u64LE), nil
+	return math.Float64frombits(order.Uint64(b)), nil
+}
+
+// GetFloat64With reads a float64 in the given byte order.
+func (f *ByteSource) GetFloat64With(order binary.ByteOrder) (float64, error) {
+	b, err := f.GetNBytes(8)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create float64: %w", err)
+	}
+	return math.Float64frombits(order.Uint64(b)), nil
+}
+
+// Finite32 maps NaN and infinite values to finite ones by clearing the
+// highest exponent bit, and returns finite values unchanged.
+func Finite32(v float32) float32 {
+	if b := math.Float32bits(v); b&0x7f800000 == 0x7f800000 {
+		return math.Float32frombits(b &^ 0x40000000)
+	}
+	return v
+}
+
+// Finite64 maps NaN and infinite values to finite ones by clearing the
+// highest exponent bit, and returns finite values unchanged.
+func Finite64(v float64) float64 {
+	if b := math.Float64bits(v); b&0x7ff0000000000000 == 0x7ff0000000000000 {
+		return math.Float64frombits(b &^ 0x4000000000000000)
 	}
-	u64BE := binary.BigEndian.Uint64(u64)
-	return math.Float64frombits(u64BE), nil
+	return v
 }
@@ -0,0 +1,607 @@
+// Copyright 2023 The go-fuzz-headers Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytesource
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/iotest"
+	"time"
+	"unicode/utf8"
+)
+
+func TestGetStringExhausted(t *testing.T) {
+	s, err := New(nil, 100).GetString()
+	if !errors.Is(err, ErrNotEnoughBytes) {
+		t.Fatalf("expected ErrNotEnoughBytes, got %v", err)
+	}
+	if s != "" {
+		t.Errorf("expected empty string on error, got %q", s)
+	}
+
+	r, err := New(nil, 100).GetRune()
+	if !errors.Is(err, ErrNotEnoughBytes) {
+		t.Fatalf("expected ErrNotEnoughBytes, got %v", err)
+	}
+	if r != nil {
+		t.Errorf("expected nil runes on error, got %q", r)
+	}
+}
+
+func TestGetBytesZeroLength(t *testing.T) {
+	b, err := New([]byte{0x00}, 100).GetBytes()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b == nil || len(b) != 0 {
+		t.Errorf("expected a non-nil empty slice, got %#v", b)
+	}
+}
+
+func TestGetBytes(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		want    string
+		wantErr bool
+	}{
+		{"with trailing data", []byte{0x02, 'a', 'b', 'c'}, "ab", false},
+		{"ends at data end", []byte{0x03, 'a', 'b', 'c'}, "abc", false},
+		{"past data end", []byte{0x04, 'a', 'b', 'c'}, "abc", false},
+		{"no data after length", []byte{0x01}, "", false},
+		{"too long", []byte{0x06, 'a', 'b', 'c', 'd', 'e', 'f'}, "abcde", false},
+		{"no length", []byte{}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := New(tt.input, 5).GetBytes()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if string(b) != tt.want {
+				t.Errorf("got %q, want %q", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetLengthPrefixed(t *testing.T) {
+	long := bytes.Repeat([]byte{'x'}, 300)
+	tests := []struct {
+		name     string
+		input    []byte
+		maxLen   int
+		want     []byte
+		position uint32
+	}{
+		{"empty", []byte{0x00, 'a'}, 10, []byte{}, 1},
+		{"short", []byte{0x02, 'h', 'i', '!'}, 10, []byte("hi"), 3},
+		{"longest single byte length", append([]byte{0xfe}, long...), 300, long[:254], 255},
+		{"escaped length", append([]byte{0xff, 0x2c, 0x01, 0x00, 0x00}, long...), 300, long, 305},
+		{"escaped short length", []byte{0xff, 0x01, 0x00, 0x00, 0x00, 'a'}, 10, []byte("a"), 6},
+		{"escaped length clamped to max", []byte{0xff, 0xff, 0xff, 0xff, 0xff, 'a', 'b', 'c'}, 2, []byte("ab"), 7},
+		{"escaped length clamped to input", []byte{0xff, 0x2c, 0x01, 0x00, 0x00, 'a', 'b'}, 1000, []byte("ab"), 7},
+		{"length clamped to max", []byte{0x03, 'a', 'b', 'c'}, 2, []byte("ab"), 3},
+		{"length clamped to input", []byte{0x03, 'a', 'b'}, 10, []byte("ab"), 3},
+		{"escaped short length clamped to input", []byte{0xff, 0x02, 0x00, 0x00, 0x00, 'a'}, 10, []byte("a"), 6},
+		{"escaped short length clamped to max", []byte{0xff, 0x14, 0x00, 0x00, 0x00, 'a', 'b', 'c'}, 2, []byte("ab"), 7},
+		{"no bytes after length", []byte{0x02}, 10, []byte{}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(tt.input, 1000)
+			// The escaped length does not depend on the byte order.
+			s.SetByteOrder(binary.BigEndian)
+			got, err := s.GetLengthPrefixed(tt.maxLen)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got == nil || !bytes.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if s.Position() != tt.position {
+				t.Errorf("got position %d, want %d", s.Position(), tt.position)
+			}
+		})
+	}
+}
+
+func TestGetLengthPrefixedErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  []byte
+		maxLen int
+	}{
+		{"no length", []byte{}, 10},
+		{"short escaped length", []byte{0xff, 0x01, 0x00}, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(tt.input, 1000)
+			if _, err := s.GetLengthPrefixed(tt.maxLen); !errors.Is(err, ErrNotEnoughBytes) {
+				t.Errorf("got error %v, want ErrNotEnoughBytes", err)
+			}
+			if s.Position() != 0 {
+				t.Errorf("expected nothing to be consumed, got position %d", s.Position())
+			}
+		})
+	}
+
+	s := New([]byte{0x00}, 1000)
+	if _, err := s.GetLengthPrefixed(-1); err == nil {
+		t.Errorf("expected an error for a negative maximum length")
+	}
+}
+
+func TestGetBytesConsecutive(t *testing.T) {
+	s := New([]byte{0x01, 'a', 0x02, 'b', 'c'}, 100)
+	for _, want := range []string{"a", "bc"} {
+		b, err := s.GetBytes()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(b) != want {
+			t.Errorf("got %q, want %q", b, want)
+		}
+	}
+	if s.Position() != 5 {
+		t.Errorf("got position %d, want 5", s.Position())
+	}
+}
+
+func TestGetPositiveInt(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		input := make([]byte, r.Intn(64))
+		r.Read(input)
+
+		s := New(input, 100)
+		for {
+			v, err := s.GetPositiveInt()
+			if err != nil {
+				break
+			}
+			if v < 0 {
+				t.Fatalf("got negative int %d from %v", v, input)
+			}
+		}
+		if s.Position() != uint32(len(input)) {
+			t.Errorf("consumed %d bytes, want all %d", s.Position(), len(input))
+		}
+	}
+
+	for _, b := range []byte{0x00, 0x7f, 0x80, 0xff} {
+		v, err := New([]byte{b}, 100).GetPositiveInt()
+		if err != nil || v != int(b) {
+			t.Errorf("got %d, %v from %#x, want %d", v, err, b, b)
+		}
+	}
+}
+
+func TestGetIntInRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max int
+	}{
+		{"negative", -10, -3},
+		{"around zero", -5, 5},
+		{"single value", 7, 7},
+		{"full width", math.MinInt, math.MaxInt},
+	}
+
+	r := rand.New(rand.NewSource(1))
+	input := make([]byte, 4096)
+	r.Read(input)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(input, 100)
+			for i := 0; i < 100; i++ {
+				v, err := s.GetIntInRange(tt.min, tt.max)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if v < tt.min || v > tt.max {
+					t.Fatalf("%d is not in [%d, %d]", v, tt.min, tt.max)
+				}
+			}
+		})
+	}
+
+	if _, err := New(input, 100).GetIntInRange(1, 0); err == nil {
+		t.Errorf("expected error when min is greater than max")
+	}
+}
+
+func TestGetUint64InRange(t *testing.T) {
+	s := New([]byte{0xff, 0xff, 0x01}, 100)
+
+	v, err := s.GetUint64InRange(10, 10)
+	if err != nil || v != 10 {
+		t.Errorf("got %d, %v, want 10", v, err)
+	}
+	if s.Position() != 0 {
+		t.Errorf("single value range should not consume bytes")
+	}
+
+	v, err = s.GetUint64InRange(100, 355)
+	if err != nil || v != 355 {
+		t.Errorf("got %d, %v, want 355", v, err)
+	}
+
+	v, err = s.GetUint64InRange(0, math.MaxUint64)
+	if err == nil {
+		t.Errorf("expected error on exhausted source, got %d", v)
+	}
+
+	if _, err := s.GetUint64InRange(2, 1); err == nil {
+		t.Errorf("expected error when min is greater than max")
+	}
+}
+
+func TestGetUTF8String(t *testing.T) {
+	s, err := New([]byte{0x03, 'a', 0xd0, 0x96, 0xff}, 100).GetUTF8String()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s != "aЖÿ" {
+		t.Errorf("got %q, want %q", s, "aЖÿ")
+	}
+
+	// An escaped length is clamped to the input rather than allocated.
+	s, err = New([]byte{0xff, 0xff, 0xff, 0xff, 0x7f, 'a'}, 100).GetUTF8String()
+	if err != nil || s != "a" {
+		t.Errorf("got %q, %v, want the remaining input", s, err)
+	}
+	// Every length is clamped to the maximum string length and to the
+	// input.
+	if s, err := New([]byte{0x03, 'a', 'b', 'c'}, 2).GetUTF8String(); err != nil || s != "ab" {
+		t.Errorf("got %q, %v, want a string clamped to 2 runes", s, err)
+	}
+	if s, err := New([]byte{0x03, 'a', 0xd0, 0x96}, 100).GetUTF8String(); err != nil || s != "aЖ" {
+		t.Errorf("got %q, %v, want the runes left", s, err)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		input := make([]byte, 300)
+		r.Read(input)
+
+		s, err := New(input, 100).GetUTF8String()
+		if err != nil {
+			continue
+		}
+		if !utf8.ValidString(s) {
+			t.Errorf("%q is not valid utf8", s)
+		}
+	}
+}
+
+func TestGetChoiceIndex(t *testing.T) {
+	s := New([]byte{0x07, 0xff}, 100)
+
+	if i, err := s.GetChoiceIndex(1); err != nil || i != 0 {
+		t.Errorf("got %d, %v, want 0", i, err)
+	}
+	if i, err := s.GetChoiceIndex(5); err != nil || i != 2 {
+		t.Errorf("got %d, %v, want 2", i, err)
+	}
+	if i, err := s.GetChoiceIndex(256); err != nil || i != 255 {
+		t.Errorf("got %d, %v, want 255", i, err)
+	}
+	if _, err := s.GetChoiceIndex(0); err == nil {
+		t.Errorf("expected error for zero choices")
+	}
+}
+
+func TestFallbackRandom(t *testing.T) {
+	input := []byte{0x01, 0x02}
+	s := New(input, 1000)
+	s.SetFallbackRandom(1)
+
+	b, err := s.GetNBytes(4)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b[0] != 0x01 || b[1] != 0x02 {
+		t.Errorf("expected input bytes first, got %v", b)
+	}
+	if _, err := s.GetBytes(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(input) != 2 || input[0] != 0x01 {
+		t.Errorf("input was modified: %v", input)
+	}
+}
+
+func TestGetStringFromBoundsLength(t *testing.T) {
+	s := New(nil, 100)
+	s.SetFallbackRandom(1)
+	for _, length := range []int{-1, 101} {
+		if _, err := s.GetStringFrom("ab", length); err == nil {
+			t.Errorf("expected an error for length %d", length)
+		}
+	}
+	if s.Position() != 0 {
+		t.Errorf("expected nothing to be consumed, got position %d", s.Position())
+	}
+	if _, err := s.GetStringFrom("", 1); err == nil {
+		t.Errorf("expected an error for no possible characters")
+	}
+	if str, err := s.GetStringFrom("ab", 100); err != nil || len(str) != 100 {
+		t.Errorf("got %q, %v, want 100 characters", str, err)
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	s := New([]byte{0x01, 0x02, 0x03}, 1000)
+	if _, err := s.GetByte(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := s.Remaining(); got != 2 {
+		t.Errorf("got %d remaining bytes, want 2", got)
+	}
+
+	s.SetFallbackRandom(1)
+	if got := s.Remaining(); got != math.MaxUint32 {
+		t.Errorf("got %d remaining bytes with a fallback, want math.MaxUint32", got)
+	}
+}
+
+func TestUnconsumed(t *testing.T) {
+	s := New([]byte{0x01, 0x02, 0x03}, 1000)
+	if got := s.Unconsumed(); !bytes.Equal(got, []byte{0x01, 0x02, 0x03}) {
+		t.Errorf("got %v unconsumed, want the whole input", got)
+	}
+	if _, err := s.GetByte(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := s.Unconsumed(); !bytes.Equal(got, []byte{0x02, 0x03}) {
+		t.Errorf("got %v unconsumed, want [2 3]", got)
+	}
+	if _, err := s.GetNBytes(2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := s.Unconsumed(); len(got) != 0 {
+		t.Errorf("got %v unconsumed, want nothing", got)
+	}
+}
+
+func TestGetExactBytes(t *testing.T) {
+	s := New([]byte{0x01, 0x02, 0x03}, 1000)
+
+	b, err := s.GetExactBytes(2)
+	if err != nil || len(b) != 2 || b[0] != 0x01 || b[1] != 0x02 {
+		t.Fatalf("got %v, %v, want [1 2]", b, err)
+	}
+
+	if _, err := s.GetExactBytes(2); !errors.Is(err, ErrNotEnoughBytes) {
+		t.Errorf("expected ErrNotEnoughBytes for a short read, got %v", err)
+	}
+	if s.Position() != 2 {
+		t.Errorf("expected a short read to consume nothing, got position %d", s.Position())
+	}
+
+	b, err = s.GetExactBytes(0)
+	if err != nil || len(b) != 0 {
+		t.Errorf("got %v, %v for a zero-length read", b, err)
+	}
+
+	if _, err := s.GetExactBytes(-1); err == nil {
+		t.Error("expected an error for a negative length")
+	}
+}
+
+func TestGetNBytesShortReadKeepsPosition(t *testing.T) {
+	s := New([]byte{0x01, 0x02, 0x03}, 1000)
+	if _, err := s.GetByte(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := s.GetNBytes(3); !errors.Is(err, ErrNotEnoughBytes) {
+		t.Errorf("expected ErrNotEnoughBytes, got %v", err)
+	}
+	if s.Position() != 1 {
+		t.Errorf("expected the position to be unchanged, got %d", s.Position())
+	}
+
+	b, err := s.GetNBytes(2)
+	if err != nil || b[0] != 0x02 || b[1] != 0x03 {
+		t.Errorf("got %v, %v, want the remaining bytes", b, err)
+	}
+}
+
+func TestNewFromReader(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	data := make([]byte, 10000)
+	r.Read(data)
+
+	// decode reads values of every width until the source is exhausted.
+	decode := func(s *ByteSource) []interface{} {
+		var values []interface{}
+		for {
+			i, err := s.GetInt()
+			if err != nil {
+				return append(values, err.Error())
+			}
+			u64, err := s.GetUint64()
+			if err != nil {
+				return append(values, err.Error())
+			}
+			b, err := s.GetBytes()
+			if err != nil {
+				return append(values, err.Error())
+			}
+			str, err := s.GetUTF8String()
+			if err != nil {
+				return append(values, err.Error())
+			}
+			values = append(values, i, u64, string(b), str)
+		}
+	}
+
+	want := decode(New(data, 1000))
+	for _, reader := range []io.Reader{bytes.NewReader(data), iotest.OneByteReader(bytes.NewReader(data))} {
+		got := decode(NewFromReader(reader, 1000))
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("reader source decoded %d values differently from the slice source (%d values)", len(got), len(want))
+		}
+	}
+}
+
+func TestReaderDropsConsumedBytes(t *testing.T) {
+	const size = 1 << 20
+	r := rand.New(rand.NewSource(1))
+	data := make([]byte, size)
+	r.Read(data)
+
+	s := NewFromReader(bytes.NewReader(data), 1000)
+	for i := 0; i < size; i++ {
+		b, err := s.GetByte()
+		if err != nil || b != data[i] {
+			t.Fatalf("got %#x, %v at %d, want %#x", b, err, i, data[i])
+		}
+		if len(s.data) > 4*readChunkSize {
+			t.Fatalf("holding %d bytes after reading %d", len(s.data), i+1)
+		}
+	}
+	if s.Position() != size {
+		t.Errorf("got position %d, want %d", s.Position(), size)
+	}
+	if _, err := s.GetByte(); !errors.Is(err, ErrNotEnoughBytes) || s.Err() != nil {
+		t.Errorf("got %v and reader error %v, want ErrNotEnoughBytes at io.EOF", err, s.Err())
+	}
+}
+
+func TestReaderError(t *testing.T) {
+	errRead := errors.New("read failed")
+	s := NewFromReader(io.MultiReader(bytes.NewReader([]byte{0x01}), iotest.ErrReader(errRead)), 1000)
+	if b, err := s.GetByte(); err != nil || b != 0x01 {
+		t.Fatalf("got %#x, %v, want 0x01", b, err)
+	}
+	if _, err := s.GetByte(); !errors.Is(err, ErrNotEnoughBytes) {
+		t.Errorf("got %v, want ErrNotEnoughBytes", err)
+	}
+	if !errors.Is(s.Err(), errRead) {
+		t.Errorf("got reader error %v, want %v", s.Err(), errRead)
+	}
+}
+
+func TestByteOrder(t *testing.T) {
+	s := New([]byte{0x01, 0x02, 0x01, 0x02, 0x03}, 1000)
+	if v, err := s.GetUint16With(binary.BigEndian); err != nil || v != 0x0102 {
+		t.Errorf("got %#x, %v, want 0x0102", v, err)
+	}
+
+	s.SetByteOrder(binary.LittleEndian)
+	if v, err := s.GetUint16(); err != nil || v != 0x0201 {
+		t.Errorf("got %#x, %v, want 0x0201", v, err)
+	}
+	if s.Position() != 4 {
+		t.Errorf("expected no byte order byte to be read, got position %d", s.Position())
+	}
+}
+
+func TestGetUint32(t *testing.T) {
+	s := New([]byte{0xde, 0xad, 0xbe, 0xef, 0xde, 0xad, 0xbe, 0xef, 0x01}, 1000)
+	if v, err := s.GetUint32(); err != nil || v != 0xefbeadde || s.Position() != 4 {
+		t.Errorf("got %#x, %v at position %d, want 0xefbeadde at position 4", v, err, s.Position())
+	}
+	if v, err := s.GetUint32With(binary.BigEndian); err != nil || v != 0xdeadbeef {
+		t.Errorf("got %#x, %v, want 0xdeadbeef", v, err)
+	}
+	if _, err := s.GetUint32(); !errors.Is(err, ErrNotEnoughBytes) {
+		t.Errorf("got error %v, want ErrNotEnoughBytes", err)
+	}
+	if s.Position() != 8 {
+		t.Errorf("expected a short read to consume nothing, got position %d", s.Position())
+	}
+}
+
+func TestDefaultByteOrder(t *testing.T) {
+	s := New([]byte{0x01, 0x02, 0x01, 0x02, 0x01}, 1000)
+	if v, err := s.GetUint16(); err != nil || v != 0x0201 || s.Position() != 2 {
+		t.Errorf("got %#x, %v at position %d, want little endian 0x0201 at position 2", v, err, s.Position())
+	}
+
+	// A nil byte order reads it from the byte following each value.
+	s.SetByteOrder(nil)
+	if v, err := s.GetUint16(); err != nil || v != 0x0102 || s.Position() != 5 {
+		t.Errorf("got %#x, %v at position %d, want big endian 0x0102 at position 5", v, err, s.Position())
+	}
+}
+
+func TestGetTime(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	input := make([]byte, 800)
+	r.Read(input)
+
+	min := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	max := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, nanos := range []bool{false, true} {
+		s := New(input, 1000)
+		s.SetTimeNanos(nanos)
+		s.SetTimeWindow(min, max)
+		for i := 0; i < 50; i++ {
+			got, err := s.GetTime()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Before(min) || !got.Before(max) {
+				t.Fatalf("nanos=%t: %s is outside [%s, %s)", nanos, got, min, max)
+			}
+			if !nanos && got.Nanosecond() != 0 {
+				t.Fatalf("expected whole seconds, got %s", got)
+			}
+			if !time.Unix(got.Unix(), int64(got.Nanosecond())).Equal(got) {
+				t.Fatalf("%s does not round-trip through Unix()", got)
+			}
+		}
+	}
+}
+
+func TestGetTimeDefaultWindow(t *testing.T) {
+	s := New([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, 1000)
+	got, err := s.GetTime()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Year() < 1970 || got.Year() >= 2100 {
+		t.Errorf("expected a time between 1970 and 2100, got %s", got)
+	}
+}
+
+func TestFinite(t *testing.T) {
+	for _, v := range []float64{math.NaN(), math.Inf(1), math.Inf(-1), math.Float64frombits(0x7ff8000000000001)} {
+		if got := Finite64(v); math.IsNaN(got) || math.IsInf(got, 0) {
+			t.Errorf("Finite64(%v) = %v, want a finite value", v, got)
+		}
+		if got := Finite32(float32(v)); math.IsNaN(float64(got)) || math.IsInf(float64(got), 0) {
+			t.Errorf("Finite32(%v) = %v, want a finite value", v, got)
+		}
+	}
+	if got := Finite64(1.5); got != 1.5 {
+		t.Errorf("Finite64(1.5) = %v, want it unchanged", got)
+	}
+	if got := Finite32(-2.5); got != -2.5 {
+		t.Errorf("Finite32(-2.5) = %v, want it unchanged", got)
+	}
+}
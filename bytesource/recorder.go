@@ -0,0 +1,277 @@
+// Copyright 2023 The go-fuzz-headers Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bytesource
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// Call is a getter call recorded by a Recorder.
+type Call struct {
+	Method string
+	Args   []interface{}
+	// Offset is the position of the source before the call and Size the
+	// number of bytes the call consumed.
+	Offset uint32
+	Size   uint32
+	Value  interface{}
+	Err    error
+}
+
+// Recorder is a Source recording every getter call made to the Source it
+// wraps, to understand how an input was decoded.
+type Recorder struct {
+	inner Source
+	trace []Call
+}
+
+var _ Source = (*Recorder)(nil)
+
+// NewRecorder returns a Recorder wrapping inner.
+func NewRecorder(inner Source) *Recorder {
+	return &Recorder{inner: inner}
+}
+
+// Trace returns the calls recorded so far, in order.
+func (r *Recorder) Trace() []Call {
+	return r.trace
+}
+
+func (r *Recorder) record(method string, start uint32, value interface{}, err error, args ...interface{}) {
+	r.trace = append(r.trace, Call{
+		Method: method,
+		Args:   args,
+		Offset: start,
+		Size:   r.inner.Position() - start,
+		Value:  value,
+		Err:    err,
+	})
+}
+
+func (r *Recorder) GetInt() (int, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetInt()
+	r.record("GetInt", start, v, err)
+	return v, err
+}
+
+func (r *Recorder) GetPositiveInt() (int, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetPositiveInt()
+	r.record("GetPositiveInt", start, v, err)
+	return v, err
+}
+
+func (r *Recorder) GetIntInRange(min, max int) (int, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetIntInRange(min, max)
+	r.record("GetIntInRange", start, v, err, min, max)
+	return v, err
+}
+
+func (r *Recorder) GetUint64InRange(min, max uint64) (uint64, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetUint64InRange(min, max)
+	r.record("GetUint64InRange", start, v, err, min, max)
+	return v, err
+}
+
+func (r *Recorder) GetChoiceIndex(n int) (int, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetChoiceIndex(n)
+	r.record("GetChoiceIndex", start, v, err, n)
+	return v, err
+}
+
+func (r *Recorder) GetByte() (byte, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetByte()
+	r.record("GetByte", start, v, err)
+	return v, err
+}
+
+func (r *Recorder) GetNBytes(n int) ([]byte, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetNBytes(n)
+	r.record("GetNBytes", start, v, err, n)
+	return v, err
+}
+
+func (r *Recorder) GetExactBytes(n int) ([]byte, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetExactBytes(n)
+	r.record("GetExactBytes", start, v, err, n)
+	return v, err
+}
+
+func (r *Recorder) GetUint16() (uint16, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetUint16()
+	r.record("GetUint16", start, v, err)
+	return v, err
+}
+
+func (r *Recorder) GetUint16With(order binary.ByteOrder) (uint16, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetUint16With(order)
+	r.record("GetUint16With", start, v, err, order)
+	return v, err
+}
+
+func (r *Recorder) GetUint32() (uint32, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetUint32()
+	r.record("GetUint32", start, v, err)
+	return v, err
+}
+
+func (r *Recorder) GetUint32With(order binary.ByteOrder) (uint32, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetUint32With(order)
+	r.record("GetUint32With", start, v, err, order)
+	return v, err
+}
+
+func (r *Recorder) GetUint64() (uint64, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetUint64()
+	r.record("GetUint64", start, v, err)
+	return v, err
+}
+
+func (r *Recorder) GetUint64With(order binary.ByteOrder) (uint64, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetUint64With(order)
+	r.record("GetUint64With", start, v, err, order)
+	return v, err
+}
+
+func (r *Recorder) GetLengthPrefixed(maxLen int) ([]byte, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetLengthPrefixed(maxLen)
+	r.record("GetLengthPrefixed", start, v, err, maxLen)
+	return v, err
+}
+
+func (r *Recorder) GetBytes() ([]byte, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetBytes()
+	r.record("GetBytes", start, v, err)
+	return v, err
+}
+
+func (r *Recorder) GetLength() (uint32, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetLength()
+	r.record("GetLength", start, v, err)
+	return v, err
+}
+
+func (r *Recorder) GetString() (string, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetString()
+	r.record("GetString", start, v, err)
+	return v, err
+}
+
+func (r *Recorder) GetUTF8String() (string, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetUTF8String()
+	r.record("GetUTF8String", start, v, err)
+	return v, err
+}
+
+func (r *Recorder) GetBool() (bool, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetBool()
+	r.record("GetBool", start, v, err)
+	return v, err
+}
+
+func (r *Recorder) GetStringFrom(possibleChars string, length int) (string, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetStringFrom(possibleChars, length)
+	r.record("GetStringFrom", start, v, err, possibleChars, length)
+	return v, err
+}
+
+func (r *Recorder) GetRune() ([]rune, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetRune()
+	r.record("GetRune", start, v, err)
+	return v, err
+}
+
+func (r *Recorder) GetFloat32() (float32, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetFloat32()
+	r.record("GetFloat32", start, v, err)
+	return v, err
+}
+
+func (r *Recorder) GetFloat32With(order binary.ByteOrder) (float32, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetFloat32With(order)
+	r.record("GetFloat32With", start, v, err, order)
+	return v, err
+}
+
+func (r *Recorder) GetFloat64() (float64, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetFloat64()
+	r.record("GetFloat64", start, v, err)
+	return v, err
+}
+
+func (r *Recorder) GetFloat64With(order binary.ByteOrder) (float64, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetFloat64With(order)
+	r.record("GetFloat64With", start, v, err, order)
+	return v, err
+}
+
+func (r *Recorder) GetTime() (time.Time, error) {
+	start := r.inner.Position()
+	v, err := r.inner.GetTime()
+	r.record("GetTime", start, v, err)
+	return v, err
+}
+
+func (r *Recorder) Position() uint32 {
+	return r.inner.Position()
+}
+
+func (r *Recorder) Remaining() uint32 {
+	return r.inner.Remaining()
+}
+
+// Err returns the error of the wrapped source, see ByteSource.Err, or nil
+// if it does not report errors.
+func (r *Recorder) Err() error {
+	if s, ok := r.inner.(interface{ Err() error }); ok {
+		return s.Err()
+	}
+	return nil
+}
+
+// Unconsumed returns the unconsumed bytes of the wrapped source, see
+// ByteSource.Unconsumed, or nil if it does not expose them.
+func (r *Recorder) Unconsumed() []byte {
+	if s, ok := r.inner.(interface{ Unconsumed() []byte }); ok {
+		return s.Unconsumed()
+	}
+	return nil
+}
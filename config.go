@@ -0,0 +1,188 @@
+// Copyright 2023 The go-fuzz-headers Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gofuzzheaders
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConsumerConfig is a snapshot of the options a ConsumeFuzzer was created
+// with. It is meant to be logged alongside fuzz findings so they can be
+// reproduced: two consumers with the same configuration decode the same
+// input the same way, given the same custom functions. Sources set with
+// WithSource or WithControlSource are only reported as set.
+type ConsumerConfig struct {
+	NilChance                 float32
+	MaxDepth                  int64
+	MinSliceElements          uint32
+	MaxSliceElements          uint32
+	MaxByteSliceLen           uint32
+	MaxStringLen              uint32
+	MaxTotalBytes             int64
+	MaxMapKeyAttempts         int
+	BoundaryCollectionSizes   bool
+	FallbackRandom            bool
+	FallbackSeed              int64
+	ByteOrder                 binary.ByteOrder
+	ControlSource             bool
+	FillZeroOnly              bool
+	PreserveNonZeroFields     bool
+	PartialOnExhaustion       bool
+	DepthScaledNilChance      bool
+	DepthScaledCollections    bool
+	UnexportedFieldStrategy   HandlingStrategy
+	UnexportedAllowedPackages []string
+	UnknownTypeStrategy       HandlingStrategy
+	DepthExceededStrategy     HandlingStrategy
+	SliceNilPolicy            SliceNilPolicy
+	DisallowCustomFuncs       bool
+	CustomFuncInheritance     bool
+	StubFuncFields            bool
+	ChannelSupport            bool
+	FiniteFloats              bool
+	ShuffledFields            bool
+	AsciiStrings              bool
+	PointerAliasing           bool
+	CustomFuncTypes           []reflect.Type
+	KindFuncKinds             []reflect.Kind
+	InterfaceTypes            []reflect.Type
+	TypeReplacementTypes      []reflect.Type
+	EnumTypes                 []reflect.Type
+	InterestingValueTypes     []reflect.Type
+	StringCorpusTypes         []reflect.Type
+	BlockedTypes              []reflect.Type
+}
+
+// Config returns a snapshot of the consumer configuration. Types, kinds and
+// packages are sorted by name.
+func (f *ConsumeFuzzer) Config() ConsumerConfig {
+	c := ConsumerConfig{
+		NilChance:               f.nilChance,
+		MaxDepth:                f.maxDepth,
+		MinSliceElements:        f.minSliceElements,
+		MaxSliceElements:        f.maxSliceElements,
+		MaxByteSliceLen:         f.maxByteSliceLen,
+		MaxStringLen:            f.maxStringLen,
+		MaxTotalBytes:           f.maxTotalBytes,
+		MaxMapKeyAttempts:       f.maxMapKeyAttempts,
+		BoundaryCollectionSizes: f.boundaryCollectionSizes,
+		FallbackRandom:          f.fallbackRandom,
+		FallbackSeed:            f.fallbackSeed,
+		ByteOrder:               f.byteOrder,
+		ControlSource:           f.control != nil,
+		FillZeroOnly:            f.fillZeroOnly,
+		PreserveNonZeroFields:   f.preserveNonZeroFields,
+		PartialOnExhaustion:     f.partialOnExhaustion,
+		DepthScaledNilChance:    f.depthScaledNilChance,
+		DepthScaledCollections:  f.depthScaledCollections,
+		UnexportedFieldStrategy: f.unexportedFieldStrategy,
+		UnknownTypeStrategy:     f.unknownTypeStrategy,
+		DepthExceededStrategy:   f.depthExceededStrategy,
+		SliceNilPolicy:          f.sliceNilPolicy,
+		DisallowCustomFuncs:     f.disallowCustomFuncs,
+		CustomFuncInheritance:   f.customFuncInheritance,
+		StubFuncFields:          f.stubFuncFields,
+		ChannelSupport:          f.channelSupport,
+		FiniteFloats:            f.finiteFloats,
+		ShuffledFields:          f.shuffledFields,
+		AsciiStrings:            f.asciiStrings,
+		PointerAliasing:         f.pointerAliasing,
+		CustomFuncTypes:         sortedTypes(f.customFuncs),
+		InterfaceTypes:          sortedTypes(f.interfaceImpls),
+		TypeReplacementTypes:    sortedTypes(f.typeReplacements),
+		EnumTypes:               sortedTypes(f.enumValues),
+		InterestingValueTypes:   sortedTypes(f.interestingValues),
+		StringCorpusTypes:       sortedTypes(f.stringCorpora),
+		BlockedTypes:            sortedTypes(f.blockedTypes),
+	}
+	for path := range f.unexportedPackages {
+		c.UnexportedAllowedPackages = append(c.UnexportedAllowedPackages, path)
+	}
+	sort.Strings(c.UnexportedAllowedPackages)
+	for k := range f.kindFuncs {
+		c.KindFuncKinds = append(c.KindFuncKinds, k)
+	}
+	sort.Slice(c.KindFuncKinds, func(i, j int) bool {
+		return c.KindFuncKinds[i].String() < c.KindFuncKinds[j].String()
+	})
+	return c
+}
+
+// sortedTypes returns the keys of m, a map keyed by reflect.Type, sorted by
+// name.
+func sortedTypes(m interface{}) []reflect.Type {
+	var types []reflect.Type
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		types = append(types, k.Interface().(reflect.Type))
+	}
+	sort.Slice(types, func(i, j int) bool {
+		return types[i].String() < types[j].String()
+	})
+	return types
+}
+
+func typeNames(types []reflect.Type) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = t.String()
+	}
+	return strings.Join(names, ",")
+}
+
+func (c ConsumerConfig) String() string {
+	kindFuncs := make([]string, len(c.KindFuncKinds))
+	for i, k := range c.KindFuncKinds {
+		kindFuncs[i] = k.String()
+	}
+	return fmt.Sprintf("nilChance=%g maxDepth=%d minSliceElements=%d maxSliceElements=%d "+
+		"maxByteSliceLen=%d maxStringLen=%d maxTotalBytes=%d maxMapKeyAttempts=%d boundaryCollectionSizes=%t "+
+		"fallbackRandom=%t fallbackSeed=%d byteOrder=%v controlSource=%t fillZeroOnly=%t preserveNonZeroFields=%t "+
+		"partialOnExhaustion=%t depthScaledNilChance=%t depthScaledCollections=%t "+
+		"unexportedFieldStrategy=%s unexportedAllowedPackages=[%s] unknownTypeStrategy=%s "+
+		"depthExceededStrategy=%s sliceNilPolicy=%s disallowCustomFuncs=%t customFuncInheritance=%t "+
+		"stubFuncFields=%t channelSupport=%t finiteFloats=%t shuffledFields=%t asciiStrings=%t pointerAliasing=%t "+
+		"customFuncs=[%s] kindFuncs=[%s] interfaces=[%s] typeReplacements=[%s] enums=[%s] interestingValues=[%s] "+
+		"stringCorpora=[%s] blockedTypes=[%s]",
+		c.NilChance, c.MaxDepth, c.MinSliceElements, c.MaxSliceElements,
+		c.MaxByteSliceLen, c.MaxStringLen, c.MaxTotalBytes, c.MaxMapKeyAttempts, c.BoundaryCollectionSizes,
+		c.FallbackRandom, c.FallbackSeed, c.ByteOrder, c.ControlSource, c.FillZeroOnly, c.PreserveNonZeroFields,
+		c.PartialOnExhaustion, c.DepthScaledNilChance, c.DepthScaledCollections,
+		c.UnexportedFieldStrategy, strings.Join(c.UnexportedAllowedPackages, ","), c.UnknownTypeStrategy,
+		c.DepthExceededStrategy, c.SliceNilPolicy, c.DisallowCustomFuncs, c.CustomFuncInheritance,
+		c.StubFuncFields, c.ChannelSupport, c.FiniteFloats, c.ShuffledFields, c.AsciiStrings, c.PointerAliasing,
+		typeNames(c.CustomFuncTypes), strings.Join(kindFuncs, ","), typeNames(c.InterfaceTypes),
+		typeNames(c.TypeReplacementTypes), typeNames(c.EnumTypes), typeNames(c.InterestingValueTypes),
+		typeNames(c.StringCorpusTypes), typeNames(c.BlockedTypes))
+}
+
+// String summarizes the configuration and the source position of f, e.g. to
+// be logged alongside fuzz findings. Unlike Config, it only reports the
+// number of custom and kind functions.
+func (f *ConsumeFuzzer) String() string {
+	remaining := "unknown"
+	if r := f.source.Remaining(); r != math.MaxUint32 {
+		remaining = strconv.FormatUint(uint64(r), 10)
+	}
+	return fmt.Sprintf("ConsumeFuzzer{nilChance=%g maxDepth=%d unexportedFieldStrategy=%s unknownTypeStrategy=%s "+
+		"depthExceededStrategy=%s customFuncs=%d kindFuncs=%d position=%d remaining=%s}",
+		f.nilChance, f.maxDepth, f.unexportedFieldStrategy, f.unknownTypeStrategy,
+		f.depthExceededStrategy, len(f.customFuncs), len(f.kindFuncs), f.source.Position(), remaining)
+}
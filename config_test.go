@@ -0,0 +1,118 @@
+// Copyright 2023 The go-fuzz-headers Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gofuzzheaders_test
+
+import (
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+
+	gofuzzheaders "github.com/kruskall/go-fuzz-headers"
+)
+
+func TestConfig(t *testing.T) {
+	c := gofuzzheaders.NewConsumer(nil,
+		gofuzzheaders.WithNilChance(0.5),
+		gofuzzheaders.WithMaxDepth(7),
+		gofuzzheaders.WithUnexportedFieldStrategy(gofuzzheaders.KeepFuzzing),
+		gofuzzheaders.WithUnknownTypeStrategy(gofuzzheaders.FailWithError),
+		gofuzzheaders.WithFallbackRandom(42),
+		gofuzzheaders.WithCustomFunction(func(a *customA, c gofuzzheaders.Continue) error {
+			return nil
+		}),
+	)
+
+	cfg := c.Config()
+	if !cfg.FallbackRandom || cfg.FallbackSeed != 42 {
+		t.Errorf("unexpected fallback random seed: %+v", cfg)
+	}
+	if cfg.NilChance != 0.5 || cfg.MaxDepth != 7 {
+		t.Errorf("unexpected nil chance or max depth: %+v", cfg)
+	}
+	if cfg.UnexportedFieldStrategy != gofuzzheaders.KeepFuzzing ||
+		cfg.UnknownTypeStrategy != gofuzzheaders.FailWithError {
+		t.Errorf("unexpected strategies: %+v", cfg)
+	}
+	if len(cfg.CustomFuncTypes) != 1 || cfg.CustomFuncTypes[0] != reflect.TypeOf(&customA{}) {
+		t.Errorf("unexpected custom function types: %v", cfg.CustomFuncTypes)
+	}
+
+	str := cfg.String()
+	for _, want := range []string{"nilChance=0.5", "maxDepth=7", "fallbackSeed=42", "unexportedFieldStrategy=KeepFuzzing", "customFuncs=[*gofuzzheaders_test.customA]"} {
+		if !strings.Contains(str, want) {
+			t.Errorf("%q does not contain %q", str, want)
+		}
+	}
+}
+
+func TestConfigDecodingOptions(t *testing.T) {
+	c := gofuzzheaders.NewConsumer(nil,
+		gofuzzheaders.WithMaxStringLen(8),
+		gofuzzheaders.WithMaxByteSliceLen(16),
+		gofuzzheaders.WithEndianness(binary.BigEndian),
+		gofuzzheaders.WithAsciiStrings(),
+		gofuzzheaders.WithShuffledFields(),
+		gofuzzheaders.WithEnumValues(reflect.TypeOf(state(0)), []interface{}{stateIdle}),
+	)
+
+	cfg := c.Config()
+	if cfg.MaxStringLen != 8 || cfg.MaxByteSliceLen != 16 || cfg.ByteOrder != binary.BigEndian {
+		t.Errorf("unexpected limits or byte order: %+v", cfg)
+	}
+	if !cfg.AsciiStrings || !cfg.ShuffledFields {
+		t.Errorf("unexpected string or field options: %+v", cfg)
+	}
+	if len(cfg.EnumTypes) != 1 || cfg.EnumTypes[0] != reflect.TypeOf(state(0)) {
+		t.Errorf("unexpected enum types: %v", cfg.EnumTypes)
+	}
+
+	str := cfg.String()
+	for _, want := range []string{"maxStringLen=8", "maxByteSliceLen=16", "byteOrder=BigEndian", "asciiStrings=true", "shuffledFields=true", "enums=[gofuzzheaders_test.state]"} {
+		if !strings.Contains(str, want) {
+			t.Errorf("%q does not contain %q", str, want)
+		}
+	}
+	if reflect.DeepEqual(cfg, gofuzzheaders.NewConsumer(nil).Config()) {
+		t.Errorf("expected the configuration to differ from the default one")
+	}
+}
+
+func TestConsumerString(t *testing.T) {
+	c := gofuzzheaders.NewConsumer([]byte{0x01, 0x02, 0x03},
+		gofuzzheaders.WithNilChance(0.5),
+		gofuzzheaders.WithMaxDepth(7),
+		gofuzzheaders.WithUnknownTypeStrategy(gofuzzheaders.FailWithError),
+		gofuzzheaders.WithCustomFunction(func(a *customA, c gofuzzheaders.Continue) error {
+			return nil
+		}),
+	)
+	var b byte
+	if err := c.GenerateStruct(&b); err != nil {
+		t.Fatalf("failed to generate byte: %v", err)
+	}
+
+	str := c.String()
+	for _, want := range []string{"nilChance=0.5", "maxDepth=7", "unknownTypeStrategy=FailWithError", "customFuncs=1", "position=1", "remaining=2"} {
+		if !strings.Contains(str, want) {
+			t.Errorf("%q does not contain %q", str, want)
+		}
+	}
+
+	c = gofuzzheaders.NewConsumer(nil, gofuzzheaders.WithFallbackRandom(1))
+	if str := c.String(); !strings.Contains(str, "remaining=unknown") {
+		t.Errorf("%q does not report an unknown remaining size", str)
+	}
+}
@@ -15,44 +15,465 @@
 package gofuzzheaders
 
 import (
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
 	"reflect"
+	"strings"
+	"sync"
 	"unsafe"
 
 	"github.com/kruskall/go-fuzz-headers/bytesource"
 )
 
+// ErrMaxTotalBytesExceeded is returned when a generation allocates more
+// elements than allowed by WithMaxTotalBytes.
+var ErrMaxTotalBytesExceeded = errors.New("max total bytes exceeded")
+
+// syncTypes are left at their zero value, the only valid state of an unused
+// lock, even when unexported fields are fuzzed.
+var syncTypes = map[reflect.Type]bool{
+	reflect.TypeOf(sync.Mutex{}):     true,
+	reflect.TypeOf(sync.RWMutex{}):   true,
+	reflect.TypeOf(sync.Once{}):      true,
+	reflect.TypeOf(sync.WaitGroup{}): true,
+}
+
+// GenerateError is returned by GenerateStruct when generation fails. It
+// records the path of the field being generated, e.g. Foo.Bar[3].Baz, and
+// the source offset at which the failure happened.
+type GenerateError struct {
+	Path   string
+	Offset uint32
+	Err    error
+}
+
+func (e *GenerateError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("failed to generate value at offset %d: %v", e.Offset, e.Err)
+	}
+	return fmt.Sprintf("failed to generate %s at offset %d: %v", e.Path, e.Offset, e.Err)
+}
+
+func (e *GenerateError) Unwrap() error {
+	return e.Err
+}
+
+// ConsumeFuzzer generates values from fuzz input. A ConsumeFuzzer is not
+// safe for concurrent use, but distinct instances share no mutable state:
+// options only hold read-only values and Clone copies every registry. To
+// generate in parallel, create or Clone one consumer per goroutine.
 type ConsumeFuzzer struct {
-	source   *bytesource.ByteSource
+	source bytesource.Source
+	// control takes the structural decisions when set, see
+	// WithControlSource.
+	control  bytesource.Source
 	curDepth int64
+	path     []pathSegment
+	// typeStack counts the struct types currently being generated.
+	typeStack map[reflect.Type]int
+	// pointers holds the pointers allocated by the current generation,
+	// by type, for WithPointerAliasing to reuse.
+	pointers map[reflect.Type][]reflect.Value
+	// ctx is the context of GenerateStructContext, nil otherwise.
+	ctx context.Context
 
 	nilChance               float32
 	maxDepth                int64
+	minSliceElements        uint32
+	maxSliceElements        uint32
+	maxByteSliceLen         uint32
+	maxStringLen            uint32
+	maxTotalBytes           int64
+	maxMapKeyAttempts       int
+	boundaryCollectionSizes bool
+	fallbackRandom          bool
+	fillZeroOnly            bool
+	preserveNonZeroFields   bool
+	partialOnExhaustion     bool
+	depthScaledNilChance    bool
+	depthScaledCollections  bool
+	fieldHook               func(path string, v reflect.Value)
+	logger                  func(format string, args ...interface{})
+	fallbackSeed            int64
+	byteOrder               binary.ByteOrder
+	allocated               int64
 	unexportedFieldStrategy HandlingStrategy
+	unexportedPackages      map[string]bool
 	unknownTypeStrategy     HandlingStrategy
+	depthExceededStrategy   HandlingStrategy
+	sliceNilPolicy          SliceNilPolicy
 	disallowCustomFuncs     bool
+	customFuncInheritance   bool
+	stubFuncFields          bool
+	channelSupport          bool
+	finiteFloats            bool
+	shuffledFields          bool
+	asciiStrings            bool
+	pointerAliasing         bool
 	customFuncs             map[reflect.Type]reflect.Value
+	builtinTypes            map[reflect.Type]bool
+	kindFuncs               map[reflect.Kind]func(Continue) (reflect.Value, error)
+	interfaceImpls          map[reflect.Type][]reflect.Type
+	typeReplacements        map[reflect.Type]func(Continue) (reflect.Value, error)
+	enumValues              map[reflect.Type][]reflect.Value
+	interestingValues       map[reflect.Type][]reflect.Value
+	stringCorpora           map[reflect.Type][]string
+	blockedTypes            map[reflect.Type]HandlingStrategy
+	podLayouts              map[reflect.Type]*podLayout
+	recordDecode            bool
+	decodeLog               []DecodeStep
 }
 
+// defaultMaxStringLen is the default maximum length of strings read from
+// the source.
+const defaultMaxStringLen = 2000000
+
 func NewConsumer(fuzzData []byte, opts ...Option) *ConsumeFuzzer {
 	cf := &ConsumeFuzzer{
-		source:      bytesource.New(fuzzData, 2000000),
 		customFuncs: make(map[reflect.Type]reflect.Value),
+		kindFuncs:   make(map[reflect.Kind]func(Continue) (reflect.Value, error)),
 		curDepth:    0,
 		maxDepth:    100,
 		nilChance:   0.2,
+
+		maxSliceElements:  50,
+		maxStringLen:      defaultMaxStringLen,
+		byteOrder:         binary.LittleEndian,
+		maxByteSliceLen:   10000000,
+		maxMapKeyAttempts: 4,
+		builtinTypes:      make(map[reflect.Type]bool),
+		interfaceImpls:    make(map[reflect.Type][]reflect.Type),
+		typeReplacements:  make(map[reflect.Type]func(Continue) (reflect.Value, error)),
+		enumValues:        make(map[reflect.Type][]reflect.Value),
+		interestingValues: make(map[reflect.Type][]reflect.Value),
+		stringCorpora:     make(map[reflect.Type][]string),
+		blockedTypes:      make(map[reflect.Type]HandlingStrategy),
+		podLayouts:        make(map[reflect.Type]*podLayout),
+		typeStack:         make(map[reflect.Type]int),
 	}
 
 	for _, opt := range opts {
 		opt(cf)
 	}
 
+	// The source is built last so that options can configure it.
+	if cf.source == nil {
+		cf.source = cf.newSource(fuzzData)
+	}
+
+	// The maximum is exclusive, a minimum equal to it leaves no length.
+	if cf.minSliceElements >= cf.maxSliceElements {
+		panic(fmt.Sprintf("min slice elements (%d) not less than max slice elements (%d)", cf.minSliceElements, cf.maxSliceElements))
+	}
+	if cf.maxDepth <= 0 {
+		panic(fmt.Sprintf("max depth must be positive, got %d", cf.maxDepth))
+	}
+	if cf.maxMapKeyAttempts < 1 {
+		panic(fmt.Sprintf("max map key attempts must be positive, got %d", cf.maxMapKeyAttempts))
+	}
+
 	return cf
 }
 
+// newSource returns a source reading data, configured by the options.
+func (f *ConsumeFuzzer) newSource(data []byte) *bytesource.ByteSource {
+	s := bytesource.New(data, f.maxStringLen)
+	if f.fallbackRandom {
+		s.SetFallbackRandom(f.fallbackSeed)
+	}
+	s.SetByteOrder(f.byteOrder)
+	return s
+}
+
+// Clone returns a consumer with the same options as f, generating from
+// data. The clone shares no mutable state with f, so both can be used from
+// different goroutines. A source set with WithSource is not cloned, the
+// clone reads from data instead. Neither is a source set with
+// WithControlSource, the clone takes every decision from data.
+func (f *ConsumeFuzzer) Clone(data []byte) *ConsumeFuzzer {
+	c := *f
+	c.source = c.newSource(data)
+	c.control = nil
+	c.curDepth = 0
+	c.path = nil
+	c.allocated = 0
+	c.pointers = nil
+	c.decodeLog = nil
+	c.typeStack = make(map[reflect.Type]int)
+	c.podLayouts = make(map[reflect.Type]*podLayout)
+	c.customFuncs = copyMap(f.customFuncs).(map[reflect.Type]reflect.Value)
+	c.builtinTypes = copyMap(f.builtinTypes).(map[reflect.Type]bool)
+	c.kindFuncs = copyMap(f.kindFuncs).(map[reflect.Kind]func(Continue) (reflect.Value, error))
+	c.interfaceImpls = copyMap(f.interfaceImpls).(map[reflect.Type][]reflect.Type)
+	c.typeReplacements = copyMap(f.typeReplacements).(map[reflect.Type]func(Continue) (reflect.Value, error))
+	c.enumValues = copyMap(f.enumValues).(map[reflect.Type][]reflect.Value)
+	c.interestingValues = copyMap(f.interestingValues).(map[reflect.Type][]reflect.Value)
+	c.stringCorpora = copyMap(f.stringCorpora).(map[reflect.Type][]string)
+	c.blockedTypes = copyMap(f.blockedTypes).(map[reflect.Type]HandlingStrategy)
+	return &c
+}
+
+// printableASCII holds the characters of strings generated with
+// WithAsciiStrings.
+const printableASCII = " !\"#$%&'()*+,-./0123456789:;<=>?@ABCDEFGHIJKLMNOPQRSTUVWXYZ[\\]^_`abcdefghijklmnopqrstuvwxyz{|}~"
+
+// getString reads a string for a string value.
+func (f *ConsumeFuzzer) getString() (string, error) {
+	if !f.asciiStrings {
+		return f.source.GetString()
+	}
+	length, err := f.source.GetPositiveInt()
+	if err != nil {
+		return "", err
+	}
+	// The length is clamped like the length of GetString.
+	if uint32(length) > f.maxStringLen {
+		length = int(f.maxStringLen)
+	}
+	if remaining := f.source.Remaining(); uint64(length) > uint64(remaining) {
+		length = int(remaining)
+	}
+	return f.source.GetStringFrom(printableASCII, length)
+}
+
+// controlSource returns the source of structural decisions, such as nil
+// pointers, collection lengths and selectors.
+func (f *ConsumeFuzzer) controlSource() bytesource.Source {
+	if f.control != nil {
+		return f.control
+	}
+	return f.source
+}
+
+// reusePointer points e at a pointer of the same type allocated earlier in
+// the current generation, if the input asks for it. It reports whether e
+// was set.
+func (f *ConsumeFuzzer) reusePointer(e reflect.Value) (bool, error) {
+	ptrs := f.pointers[e.Type()]
+	if len(ptrs) == 0 {
+		return false, nil
+	}
+	b, err := f.controlSource().GetByte()
+	if err != nil {
+		return false, err
+	}
+	if b%4 != 0 {
+		return false, nil
+	}
+	e.Set(ptrs[int(b/4)%len(ptrs)])
+	return true, nil
+}
+
+// copyMap returns a shallow copy of the map m.
+func copyMap(m interface{}) interface{} {
+	v := reflect.ValueOf(m)
+	c := reflect.MakeMapWithSize(v.Type(), v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		c.SetMapIndex(iter.Key(), iter.Value())
+	}
+	return c.Interface()
+}
+
+// Generate fills the value pointed to by target. Any type the consumer can
+// generate is accepted, e.g. a pointer to a struct, slice, map, string or
+// number. Types that can only be generated when enabled by an option, such
+// as funcs and channels, or that cannot be generated at all, such as complex
+// numbers, return an error unless a custom function handles them.
+//
+// Each value is generated by the first of these handlers that applies:
+//
+//  1. a custom function, type replacement, enum values, interesting value
+//     or string corpus registered for its exact type, in that order,
+//  2. the implementations registered for its interface type,
+//  3. a kind function registered for its kind,
+//  4. a built-in handler, enabled by options such as WithBigNumberSupport,
+//  5. the default generation of its kind.
+func (f *ConsumeFuzzer) Generate(target interface{}) error {
+	e, err := targetValue(target)
+	if err != nil {
+		return err
+	}
+	if err := f.checkTarget(e.Type()); err != nil {
+		return err
+	}
+	if f.curDepth == 0 {
+		f.allocated = 0
+		f.pointers = nil
+	}
+	return f.topLevelError(f.fuzzStruct(e))
+}
+
+// GenerateValue allocates a value of type t, fills it like Generate and
+// returns it. It is useful when only the type is known, e.g. to iterate over
+// types registered at runtime.
+func (f *ConsumeFuzzer) GenerateValue(t reflect.Type) (reflect.Value, error) {
+	if t == nil {
+		return reflect.Value{}, errors.New("type is nil")
+	}
+	v := reflect.New(t)
+	if err := f.Generate(v.Interface()); err != nil {
+		return reflect.Value{}, err
+	}
+	return v.Elem(), nil
+}
+
+// GenerateStruct is like Generate. Despite its name, it is not limited to
+// structs.
 func (f *ConsumeFuzzer) GenerateStruct(targetStruct interface{}) error {
-	e := reflect.ValueOf(targetStruct).Elem()
-	return f.fuzzStruct(e)
+	return f.Generate(targetStruct)
+}
+
+// checkTarget returns an error if values of type t pointed to by a target
+// cannot be generated.
+func (f *ConsumeFuzzer) checkTarget(t reflect.Type) error {
+	if f.hasHandlerForType(t) {
+		return nil
+	}
+	if _, ok := f.typeReplacements[t]; ok {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Func:
+		if !f.stubFuncFields {
+			return fmt.Errorf("cannot generate a %s target without WithStubFuncFields", t)
+		}
+	case reflect.Chan:
+		if !f.channelSupport {
+			return fmt.Errorf("cannot generate a %s target without WithChannelSupport", t)
+		}
+	case reflect.Interface:
+		if len(f.interfaceImpls[t]) == 0 {
+			return fmt.Errorf("cannot generate a %s target without registered implementations", t)
+		}
+	case reflect.Uintptr, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+		return fmt.Errorf("cannot generate a %s target", t)
+	}
+	return nil
+}
+
+// GenerateStructContext is like GenerateStruct but stops with the error of
+// ctx once it is done. The context is checked before each value is
+// generated, which bounds the time spent on pathological inputs.
+func (f *ConsumeFuzzer) GenerateStructContext(ctx context.Context, targetStruct interface{}) error {
+	prev := f.ctx
+	f.ctx = ctx
+	defer func() { f.ctx = prev }()
+	return f.GenerateStruct(targetStruct)
+}
+
+// GenerateStructConsumed is like GenerateStruct but also returns the number
+// of input bytes read, which is useful to trim corpus entries.
+func (f *ConsumeFuzzer) GenerateStructConsumed(targetStruct interface{}) (int, error) {
+	start := f.source.Position()
+	err := f.GenerateStruct(targetStruct)
+	return int(f.source.Position() - start), err
+}
+
+// Unconsumed returns the input bytes not read yet, e.g. to trim corpus
+// entries or check that the input was fully consumed. It returns nil if the
+// source set with WithSource does not expose its input.
+func (f *ConsumeFuzzer) Unconsumed() []byte {
+	if s, ok := f.source.(interface{ Unconsumed() []byte }); ok {
+		return s.Unconsumed()
+	}
+	return nil
+}
+
+// GenerateMap fills the map pointed to by target, like a map field of a
+// struct passed to GenerateStruct.
+func (f *ConsumeFuzzer) GenerateMap(target interface{}) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Map {
+		return fmt.Errorf("GenerateMap requires a non-nil pointer to a map, got %T", target)
+	}
+	return f.GenerateStruct(target)
+}
+
+// targetValue returns the value pointed to by target, which must be a
+// non-nil pointer.
+func targetValue(target interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(target)
+	switch {
+	case !v.IsValid():
+		return reflect.Value{}, errors.New("target is nil, expected a non-nil pointer")
+	case v.Kind() != reflect.Ptr:
+		return reflect.Value{}, fmt.Errorf("target must be a pointer, got %T", target)
+	case v.IsNil():
+		return reflect.Value{}, fmt.Errorf("target is a nil %T", target)
+	}
+	return v.Elem(), nil
+}
+
+// topLevelError drops source exhaustion errors of a top level generation
+// when partial values are allowed. Exhaustion caused by a failing reader is
+// reported with the reader error instead.
+func (f *ConsumeFuzzer) topLevelError(err error) error {
+	if f.curDepth != 0 || !errors.Is(err, bytesource.ErrNotEnoughBytes) {
+		return err
+	}
+	if s, ok := f.source.(interface{ Err() error }); ok && s.Err() != nil {
+		return fmt.Errorf("%v: %w", err, s.Err())
+	}
+	if f.partialOnExhaustion {
+		return nil
+	}
+	return err
+}
+
+// Mutate re-generates the named fields of the struct pointed to by target and
+// leaves every other field intact. Nested fields are named with a dotted
+// path, e.g. "Foo.Bar".
+func (f *ConsumeFuzzer) Mutate(target interface{}, mutateFields ...string) error {
+	e, err := targetValue(target)
+	if err != nil {
+		return err
+	}
+	if f.curDepth == 0 {
+		f.allocated = 0
+		f.pointers = nil
+	}
+	for _, name := range mutateFields {
+		v := e
+		for _, fieldName := range strings.Split(name, ".") {
+			if v.Kind() == reflect.Ptr {
+				if v.IsNil() {
+					return fmt.Errorf("cannot mutate %s: nil pointer", name)
+				}
+				v = v.Elem()
+			}
+			if v.Kind() != reflect.Struct {
+				return fmt.Errorf("cannot mutate %s: %s is not a struct", name, v.Type())
+			}
+			v = v.FieldByName(fieldName)
+			if !v.IsValid() {
+				return fmt.Errorf("cannot mutate %s: no such field", name)
+			}
+		}
+
+		f.pushPath(name)
+		err := f.fuzzStruct(v)
+		if err == nil && f.fieldHook != nil {
+			f.fieldHook(f.pathString(), v)
+		}
+		f.popPath()
+		if err != nil {
+			return f.topLevelError(err)
+		}
+	}
+	return nil
+}
+
+func (f *ConsumeFuzzer) continuation() Continue {
+	c := Continue{
+		source: f.source,
+		f:      f,
+	}
+	c.Source, _ = f.source.(*bytesource.ByteSource)
+	return c
 }
 
 func (f *ConsumeFuzzer) setCustom(v reflect.Value) error {
@@ -64,6 +485,8 @@ func (f *ConsumeFuzzer) setCustom(v reflect.Value) error {
 
 	switch v.Kind() {
 	case reflect.Ptr:
+		// Slices and arrays are handed to custom functions as *[]T and
+		// *[N]T so the function can allocate or resize them itself.
 		if v.IsNil() {
 			if !v.CanSet() {
 				return fmt.Errorf("could not use a custom function")
@@ -81,10 +504,10 @@ func (f *ConsumeFuzzer) setCustom(v reflect.Value) error {
 		return fmt.Errorf("could not use a custom function")
 	}
 
-	verr := doCustom.Call([]reflect.Value{v, reflect.ValueOf(Continue{
-		Source: f.source,
-		f:      f,
-	})})
+	if f.logger != nil {
+		f.logger("%s: calling custom function for %s", f.pathString(), v.Type())
+	}
+	verr := doCustom.Call([]reflect.Value{v, reflect.ValueOf(f.continuation())})
 
 	// check if we return an error
 	if verr[0].IsNil() {
@@ -96,9 +519,119 @@ func (f *ConsumeFuzzer) setCustom(v reflect.Value) error {
 	return fmt.Errorf("could not use a custom function: %s", verr[0].String())
 }
 
-func (f *ConsumeFuzzer) fuzzStruct(e reflect.Value) error {
+// pathSegment is one segment of the path of the value being generated: a
+// field name, a map key or a collection index. Keys and indices are only
+// formatted by pathString, as most paths are never printed.
+type pathSegment struct {
+	name  string
+	key   reflect.Value
+	index int
+}
+
+func (f *ConsumeFuzzer) pushPath(segment string) {
+	f.path = append(f.path, pathSegment{name: segment})
+}
+
+// pushIndex pushes the index i of a collection element.
+func (f *ConsumeFuzzer) pushIndex(i int) {
+	f.path = append(f.path, pathSegment{index: i})
+}
+
+// pushKey pushes the key of a map value.
+func (f *ConsumeFuzzer) pushKey(key reflect.Value) {
+	f.path = append(f.path, pathSegment{key: key})
+}
+
+func (f *ConsumeFuzzer) popPath() {
+	f.path = f.path[:len(f.path)-1]
+}
+
+// pathString returns the path of the value being generated, e.g.
+// Foo.Bar[3].Baz.
+func (f *ConsumeFuzzer) pathString() string {
+	var path strings.Builder
+	for i, segment := range f.path {
+		switch {
+		case segment.key.IsValid():
+			fmt.Fprintf(&path, "[%v]", segment.key)
+		case segment.name == "":
+			fmt.Fprintf(&path, "[%d]", segment.index)
+		default:
+			if i > 0 && !strings.HasPrefix(segment.name, "[") {
+				path.WriteByte('.')
+			}
+			path.WriteString(segment.name)
+		}
+	}
+	return path.String()
+}
+
+// generateError wraps err with the current path and source position, unless
+// it has already been wrapped further down the recursion.
+func (f *ConsumeFuzzer) generateError(err error) error {
+	var genErr *GenerateError
+	if errors.As(err, &genErr) {
+		return err
+	}
+
+	if f.logger != nil && errors.Is(err, bytesource.ErrNotEnoughBytes) {
+		f.logger("%s: source exhausted at offset %d", f.pathString(), f.source.Position())
+	}
+	return &GenerateError{
+		Path:   f.pathString(),
+		Offset: f.source.Position(),
+		Err:    err,
+	}
+}
+
+// setConstructed sets e to the value returned by construct, a kind
+// function or a type replacement.
+func (f *ConsumeFuzzer) setConstructed(e reflect.Value, construct func(Continue) (reflect.Value, error), what string) error {
+	v, err := construct(f.continuation())
+	if err != nil {
+		return fmt.Errorf("could not use a %s: %w", what, err)
+	}
+
+	switch {
+	case !v.IsValid():
+		e.Set(reflect.Zero(e.Type()))
+	case v.Type().AssignableTo(e.Type()):
+		e.Set(v)
+	case v.Type().ConvertibleTo(e.Type()):
+		e.Set(v.Convert(e.Type()))
+	default:
+		return fmt.Errorf("could not use a %s: cannot assign %s to %s", what, v.Type(), e.Type())
+	}
+	return nil
+}
+
+func (f *ConsumeFuzzer) fuzzStruct(e reflect.Value) (err error) {
+	// Unexported fields are generated through a settable copy, which is
+	// the one recorded.
+	record, start := f.recordDecode && e.CanSet(), f.source.Position()
+	defer func() {
+		if err != nil {
+			err = f.generateError(err)
+		} else if record {
+			f.recordStep(start, e)
+		}
+	}()
+
+	if f.ctx != nil {
+		if err := f.ctx.Err(); err != nil {
+			return err
+		}
+	}
 	if f.curDepth >= f.maxDepth {
-		// return err or nil here?
+		return f.depthExceeded()
+	}
+	if strategy, ok := f.blockedTypes[e.Type()]; ok {
+		if strategy == FailWithError {
+			return fmt.Errorf("blocked type: %s", e.Type())
+		}
+		return nil
+	}
+	if syncTypes[e.Type()] {
 		return nil
 	}
 	f.curDepth++
@@ -113,83 +646,334 @@ func (f *ConsumeFuzzer) fuzzStruct(e reflect.Value) error {
 			return fmt.Errorf("found unexported field: %s", e.String())
 		}
 
-		if !e.CanAddr() {
-			return fmt.Errorf("failed to fuzz unexported field, value is not addressable: %s", e.String())
+		e, err := settable(e)
+		if err != nil {
+			return err
+		}
+		return f.fuzzStruct(e)
+	}
+
+	if f.fillZeroOnly && !e.IsZero() {
+		switch e.Kind() {
+		case reflect.Struct, reflect.Array:
+			// Fill the zero fields or elements.
+		case reflect.Ptr:
+			return f.fuzzStruct(e.Elem())
+		default:
+			return nil
 		}
+	}
 
-		e = reflect.NewAt(e.Type(), unsafe.Pointer(e.UnsafeAddr())).Elem()
-		return f.fuzzStruct(e)
+	// Handlers are tried in the order of precedence documented on Generate.
+	custom, hasCustom := f.customTarget(e)
+	if hasCustom && !f.builtinTypes[custom.Type()] {
+		return f.setCustom(custom)
 	}
 
-	// We check if we should check for custom functions
-	if !f.disallowCustomFuncs && e.IsValid() && e.CanAddr() && f.hasCustomFunction(e.Addr()) {
-		return f.setCustom(e.Addr())
+	if construct, ok := f.typeReplacements[e.Type()]; ok {
+		return f.setConstructed(e, construct, "type replacement")
+	}
+
+	if values, ok := f.enumValues[e.Type()]; ok {
+		i, err := f.source.GetChoiceIndex(len(values))
+		if err != nil {
+			return err
+		}
+		e.Set(values[i])
+		return nil
+	}
+
+	if values, ok := f.interestingValues[e.Type()]; ok {
+		b, err := f.controlSource().GetByte()
+		if err != nil {
+			return err
+		}
+		if b%4 == 0 {
+			e.Set(values[int(b/4)%len(values)])
+			return nil
+		}
+	}
+
+	if corpus, ok := f.stringCorpora[e.Type()]; ok {
+		i, err := f.source.GetChoiceIndex(len(corpus))
+		if err != nil {
+			return err
+		}
+		return setFromText(e, corpus[i])
+	}
+
+	// Registered implementations are generated by the interface case below.
+	hasImpls := e.Kind() == reflect.Interface && len(f.interfaceImpls[e.Type()]) > 0
+	if kindFunc, ok := f.kindFuncs[e.Kind()]; ok && !f.disallowCustomFuncs && !hasImpls {
+		return f.setConstructed(e, kindFunc, "kind function")
+	}
+
+	if hasCustom {
+		return f.setCustom(custom)
 	}
 
 	switch e.Kind() {
 	case reflect.Struct:
-		for i := 0; i < e.NumField(); i++ {
-			v := e.Field(i)
-			if err := f.fuzzStruct(v); err != nil {
+		if f.fuzzPOD(e) {
+			return nil
+		}
+		return f.fuzzFields(e)
+	case reflect.Array:
+		for i := 0; i < e.Len(); i++ {
+			f.pushIndex(i)
+			err := f.fuzzStruct(e.Index(i))
+			f.popPath()
+			if err != nil {
 				return err
 			}
 		}
 	case reflect.String:
-		str, err := f.source.GetString()
+		str, err := f.getString()
 		if err != nil {
 			return err
 		}
-		if e.CanSet() {
+		if err := f.allocate(len(str)); err != nil {
+			return err
+		}
+		// Skip the redundant Set when the field is already zero.
+		if e.CanSet() && (str != "" || e.Len() != 0) {
 			e.SetString(str)
 		}
 	case reflect.Slice:
-		randByte, err := f.source.GetByte()
+		isNil, err := f.shouldBeNil(false)
 		if err != nil {
 			return err
 		}
-
-		if float32(randByte%10) < f.nilChance*10 {
+		if isNil {
+			if f.sliceNilPolicy == SliceEmptyNotNil && e.CanSet() {
+				e.Set(reflect.MakeSlice(e.Type(), 0, 0))
+			}
 			return nil
 		}
 
-		var maxElements uint32
-		// Byte slices should not be restricted
-		if e.Type().String() == "[]uint8" {
-			maxElements = 10000000
-		} else {
-			maxElements = 50
-		}
-
-		randQty, err := f.source.GetUint32()
+		numOfElements, err := f.sliceLen(e.Type())
 		if err != nil {
 			return err
 		}
-		numOfElements := randQty % maxElements
+		if numOfElements == 0 && f.sliceNilPolicy == SliceNilWhenEmpty {
+			if e.CanSet() {
+				e.Set(reflect.Zero(e.Type()))
+			}
+			return nil
+		}
+		if err := f.allocate(numOfElements); err != nil {
+			return err
+		}
 
-		uu := reflect.MakeSlice(e.Type(), int(numOfElements), int(numOfElements))
+		uu := reflect.MakeSlice(e.Type(), numOfElements, numOfElements)
 
-		for i := 0; i < int(numOfElements); i++ {
-			// If we have more than 10, then we can proceed with that.
-			if err := f.fuzzStruct(uu.Index(i)); err != nil {
-				if i >= 10 {
+		for i := 0; i < numOfElements; i++ {
+			f.pushIndex(i)
+			err := f.fuzzStruct(uu.Index(i))
+			f.popPath()
+			if err != nil {
+				// Running out of input after 10 elements keeps the
+				// slice, any other error, e.g. a cancelled context,
+				// aborts generation.
+				if i >= 10 && errors.Is(err, bytesource.ErrNotEnoughBytes) {
 					if e.CanSet() {
 						e.Set(uu)
 					}
 					return nil
-				} else {
-					return err
 				}
+				return err
 			}
 		}
 		if e.CanSet() {
 			e.Set(uu)
 		}
+	case reflect.Bool, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Float32, reflect.Float64:
+		return f.fuzzPrimitive(e)
+	case reflect.Map:
+		if e.CanSet() {
+			isNil, err := f.shouldBeNil(false)
+			if err != nil {
+				return err
+			}
+			if isNil {
+				return nil
+			}
+
+			e.Set(reflect.MakeMap(e.Type()))
+			numOfElements, err := f.mapLen()
+			if err != nil {
+				return err
+			}
+			if err := f.allocate(numOfElements); err != nil {
+				return err
+			}
+			for i := 0; i < numOfElements; i++ {
+				key := reflect.New(e.Type().Key()).Elem()
+				// Retry on key collisions. Once out of attempts the
+				// colliding key is kept and its value overwritten.
+				for attempt := 1; ; attempt++ {
+					f.pushPath("[key]")
+					err := f.fuzzStruct(key)
+					f.popPath()
+					if err != nil {
+						return err
+					}
+					if attempt >= f.maxMapKeyAttempts || !e.MapIndex(key).IsValid() {
+						break
+					}
+				}
+				val := reflect.New(e.Type().Elem()).Elem()
+				f.pushKey(key)
+				err := f.fuzzStruct(val)
+				f.popPath()
+				if err != nil {
+					return err
+				}
+				e.SetMapIndex(key, val)
+			}
+		}
+	case reflect.Ptr:
+		if e.CanSet() {
+			// Pointers back to a type being generated are left nil more
+			// often so recursive types terminate with natural shapes.
+			isNil, err := f.shouldBeNil(f.typeStack[e.Type().Elem()] > 0)
+			if err != nil {
+				return err
+			}
+			if isNil {
+				return nil
+			}
+
+			if f.pointerAliasing {
+				reused, err := f.reusePointer(e)
+				if err != nil || reused {
+					return err
+				}
+			}
+
+			e.Set(reflect.New(e.Type().Elem()))
+			if f.pointerAliasing {
+				// The pointer is remembered before its value is generated
+				// so that values below it can point back to it.
+				if f.pointers == nil {
+					f.pointers = make(map[reflect.Type][]reflect.Value)
+				}
+				f.pointers[e.Type()] = append(f.pointers[e.Type()], e)
+			}
+
+			// A non-nil pointer to an interface points at a registered
+			// implementation rather than rolling nilChance a second time.
+			elemType := e.Type().Elem()
+			if elemType.Kind() == reflect.Interface && len(f.interfaceImpls[elemType]) > 0 && !f.hasCustomFunction(e) {
+				v, err := f.generateImplementation(elemType)
+				if err != nil {
+					return err
+				}
+				e.Elem().Set(v)
+				return nil
+			}
+
+			if err := f.fuzzStruct(e.Elem()); err != nil {
+				return err
+			}
+			return nil
+		}
+	case reflect.Interface:
+		if len(f.interfaceImpls[e.Type()]) == 0 {
+			return f.unknownType(e)
+		}
+		if e.CanSet() {
+			isNil, err := f.shouldBeNil(false)
+			if err != nil {
+				return err
+			}
+			if isNil {
+				return nil
+			}
+
+			v, err := f.generateImplementation(e.Type())
+			if err != nil {
+				return err
+			}
+			e.Set(v)
+		}
+	case reflect.Chan:
+		if !f.channelSupport {
+			return f.unknownType(e)
+		}
+		if e.CanSet() {
+			return f.fuzzChan(e)
+		}
+	case reflect.Func:
+		if !f.stubFuncFields {
+			return f.unknownType(e)
+		}
+		if e.CanSet() {
+			e.Set(stubFunc(e.Type()))
+		}
+	default:
+		return f.unknownType(e)
+	}
+	return nil
+}
+
+// maxChanBuffer is the maximum buffer size of generated channels.
+const maxChanBuffer = 16
+
+// fuzzChan sets e to a channel with a fuzzed buffer size, holding up to
+// that many fuzzed elements.
+func (f *ConsumeFuzzer) fuzzChan(e reflect.Value) error {
+	size, err := f.controlSource().GetIntInRange(0, maxChanBuffer)
+	if err != nil {
+		return err
+	}
+	count, err := f.controlSource().GetIntInRange(0, size)
+	if err != nil {
+		return err
+	}
+	if err := f.allocate(count); err != nil {
+		return err
+	}
+	// Only bidirectional channels can be made, and sent to. They are
+	// converted to the direction of e afterwards.
+	ch := reflect.MakeChan(reflect.ChanOf(reflect.BothDir, e.Type().Elem()), size)
+	for i := 0; i < count; i++ {
+		v := reflect.New(e.Type().Elem()).Elem()
+		f.pushIndex(i)
+		err := f.fuzzStruct(v)
+		f.popPath()
+		if err != nil {
+			return err
+		}
+		ch.Send(v)
+	}
+	e.Set(ch.Convert(e.Type()))
+	return nil
+}
+
+// stubFunc returns a function of type t doing nothing and returning zero
+// values.
+func stubFunc(t reflect.Type) reflect.Value {
+	return reflect.MakeFunc(t, func([]reflect.Value) []reflect.Value {
+		results := make([]reflect.Value, t.NumOut())
+		for i := range results {
+			results[i] = reflect.Zero(t.Out(i))
+		}
+		return results
+	})
+}
+
+// fuzzPrimitive generates a fixed-width value: a bool, integer or float.
+func (f *ConsumeFuzzer) fuzzPrimitive(e reflect.Value) error {
+	switch e.Kind() {
 	case reflect.Uint16:
 		newInt, err := f.source.GetUint16()
 		if err != nil {
 			return err
 		}
-		if e.CanSet() {
+		if e.CanSet() && e.Uint() != uint64(newInt) {
 			e.SetUint(uint64(newInt))
 		}
 	case reflect.Uint32:
@@ -197,7 +981,7 @@ func (f *ConsumeFuzzer) fuzzStruct(e reflect.Value) error {
 		if err != nil {
 			return err
 		}
-		if e.CanSet() {
+		if e.CanSet() && e.Uint() != uint64(newInt) {
 			e.SetUint(uint64(newInt))
 		}
 	case reflect.Uint64:
@@ -205,7 +989,7 @@ func (f *ConsumeFuzzer) fuzzStruct(e reflect.Value) error {
 		if err != nil {
 			return err
 		}
-		if e.CanSet() {
+		if e.CanSet() && e.Uint() != uint64(newInt) {
 			e.SetUint(uint64(newInt))
 		}
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
@@ -213,7 +997,7 @@ func (f *ConsumeFuzzer) fuzzStruct(e reflect.Value) error {
 		if err != nil {
 			return err
 		}
-		if e.CanSet() {
+		if e.CanSet() && e.Int() != int64(newInt) {
 			e.SetInt(int64(newInt))
 		}
 	case reflect.Float32:
@@ -221,7 +1005,10 @@ func (f *ConsumeFuzzer) fuzzStruct(e reflect.Value) error {
 		if err != nil {
 			return err
 		}
-		if e.CanSet() {
+		if f.finiteFloats {
+			newFloat = bytesource.Finite32(newFloat)
+		}
+		if e.CanSet() && math.Float64bits(e.Float()) != math.Float64bits(float64(newFloat)) {
 			e.SetFloat(float64(newFloat))
 		}
 	case reflect.Float64:
@@ -229,7 +1016,10 @@ func (f *ConsumeFuzzer) fuzzStruct(e reflect.Value) error {
 		if err != nil {
 			return err
 		}
-		if e.CanSet() {
+		if f.finiteFloats {
+			newFloat = bytesource.Finite64(newFloat)
+		}
+		if e.CanSet() && math.Float64bits(e.Float()) != math.Float64bits(newFloat) {
 			e.SetFloat(float64(newFloat))
 		}
 	case reflect.Bool:
@@ -238,76 +1028,350 @@ func (f *ConsumeFuzzer) fuzzStruct(e reflect.Value) error {
 			return err
 		}
 
-		if e.CanSet() {
+		if e.CanSet() && e.Bool() != newBool {
 			e.SetBool(newBool)
 		}
-	case reflect.Map:
-		if e.CanSet() {
-			randByte, err := f.source.GetByte()
-			if err != nil {
-				return err
-			}
-
-			if float32(randByte%10) < f.nilChance*10 {
-				return nil
-			}
-
-			e.Set(reflect.MakeMap(e.Type()))
-			const maxElements = 50
-			randQty, err := f.source.GetInt()
-			if err != nil {
-				return err
-			}
-			numOfElements := randQty % maxElements
-			for i := 0; i < numOfElements; i++ {
-				key := reflect.New(e.Type().Key()).Elem()
-				if err := f.fuzzStruct(key); err != nil {
-					return err
-				}
-				val := reflect.New(e.Type().Elem()).Elem()
-				if err = f.fuzzStruct(val); err != nil {
-					return err
-				}
-				e.SetMapIndex(key, val)
-			}
-		}
-	case reflect.Ptr:
-		if e.CanSet() {
-			randByte, err := f.source.GetByte()
-			if err != nil {
-				return err
-			}
-
-			if float32(randByte%10) < f.nilChance*10 {
-				return nil
-			}
-
-			e.Set(reflect.New(e.Type().Elem()))
-			if err := f.fuzzStruct(e.Elem()); err != nil {
-				return err
-			}
-			return nil
-		}
 	case reflect.Uint8:
 		b, err := f.source.GetByte()
 		if err != nil {
 			return err
 		}
-		if e.CanSet() {
+		if e.CanSet() && e.Uint() != uint64(b) {
 			e.SetUint(uint64(b))
 		}
-	default:
-		if f.unknownTypeStrategy == FailWithError {
-			if !e.IsValid() {
-				return fmt.Errorf("unknown invalid type: %s", e.String())
-			}
-			return fmt.Errorf("unknown type: kind: %s: %s", e.Kind(), e.String())
+	}
+	return nil
+}
+
+// fuzzFields generates the fields of the struct e one by one.
+func (f *ConsumeFuzzer) fuzzFields(e reflect.Value) error {
+	f.typeStack[e.Type()]++
+	defer func() { f.typeStack[e.Type()]-- }()
+	order, err := f.fieldOrder(e.NumField())
+	if err != nil {
+		return err
+	}
+	var checksums []int
+	for _, i := range order {
+		v := e.Field(i)
+		if f.preserveNonZeroFields && !v.IsZero() {
+			continue
+		}
+		sf := e.Type().Field(i)
+		tag := parseFieldTag(sf.Tag.Get("fuzz"))
+		if tag.has("crc32") {
+			checksums = append(checksums, i)
+		}
+		f.pushPath(sf.Name)
+		switch {
+		case sf.Anonymous && !v.CanSet() && v.CanAddr():
+			err = f.fuzzEmbedded(v, tag, sf.PkgPath)
+		case sf.PkgPath != "" && f.unexportedFieldStrategy == KeepFuzzing && !f.unexportedAllowed(sf.PkgPath):
+			// Unexported fields of packages not allowed are left
+			// untouched, as with IgnoreValue.
+		default:
+			err = f.fuzzField(v, tag)
+		}
+		if err == nil && f.fieldHook != nil {
+			f.fieldHook(f.pathString(), v)
+		}
+		f.popPath()
+		if err != nil {
+			return err
 		}
 	}
+	if len(checksums) > 0 {
+		return f.checksumFields(e, checksums)
+	}
 	return nil
 }
 
+// fieldOrder returns the order in which to generate n struct fields: the
+// declaration order, or a permutation read from the source with
+// WithShuffledFields.
+func (f *ConsumeFuzzer) fieldOrder(n int) ([]int, error) {
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	if !f.shuffledFields {
+		return order, nil
+	}
+	for i := n - 1; i > 0; i-- {
+		j, err := f.controlSource().GetChoiceIndex(i + 1)
+		if err != nil {
+			return nil, err
+		}
+		order[i], order[j] = order[j], order[i]
+	}
+	return order, nil
+}
+
+// fuzzEmbedded generates the unexported embedded field v. Embedded types
+// with a custom function are generated by it when custom functions are
+// inherited. The exported fields of an embedded struct are promoted, so
+// they are generated whatever the unexported field strategy. Anything else
+// is handled as any unexported field of pkgPath.
+func (f *ConsumeFuzzer) fuzzEmbedded(v reflect.Value, tag fieldTag, pkgPath string) error {
+	switch {
+	case f.customFuncInheritance && v.CanAddr() && f.hasCustomFunction(v.Addr()):
+		v, err := settable(v)
+		if err != nil {
+			return err
+		}
+		return f.fuzzField(v, tag)
+	case v.Kind() == reflect.Struct && (f.unexportedFieldStrategy != KeepFuzzing || !f.unexportedAllowed(pkgPath)) && !f.isBlocked(v.Type()):
+		// Only the embedding is unexported, the exported fields of v
+		// are settable.
+		return f.fuzzFields(v)
+	}
+	if f.unexportedFieldStrategy == KeepFuzzing && !f.unexportedAllowed(pkgPath) {
+		return nil
+	}
+	return f.fuzzField(v, tag)
+}
+
+// unexportedAllowed reports whether unexported fields declared in the
+// package pkgPath can be fuzzed, see WithUnexportedAllowedPackages.
+func (f *ConsumeFuzzer) unexportedAllowed(pkgPath string) bool {
+	return f.unexportedPackages == nil || f.unexportedPackages[pkgPath]
+}
+
+// settable returns a settable value sharing the memory of the unexported
+// value e, or an error if e is not addressable, in which case UnsafeAddr
+// would panic.
+func settable(e reflect.Value) (reflect.Value, error) {
+	if !e.CanAddr() {
+		return reflect.Value{}, fmt.Errorf("failed to fuzz unexported field, value is not addressable: %s", e.String())
+	}
+	return reflect.NewAt(e.Type(), unsafe.Pointer(e.UnsafeAddr())).Elem(), nil
+}
+
+// depthExceeded handles a value nested deeper than the maximum depth.
+func (f *ConsumeFuzzer) depthExceeded() error {
+	if f.logger != nil {
+		f.logger("%s: max depth %d exceeded", f.pathString(), f.maxDepth)
+	}
+	if f.depthExceededStrategy == FailWithError {
+		return fmt.Errorf("max depth %d exceeded", f.maxDepth)
+	}
+	return nil
+}
+
+func (f *ConsumeFuzzer) unknownType(e reflect.Value) error {
+	if f.unknownTypeStrategy == FailWithError {
+		if !e.IsValid() {
+			return fmt.Errorf("unknown invalid type: %s", e.String())
+		}
+		return fmt.Errorf("unknown type: kind: %s: %s", e.Kind(), e.String())
+	}
+	return nil
+}
+
+// allocate accounts n generated elements against the WithMaxTotalBytes
+// budget.
+func (f *ConsumeFuzzer) allocate(n int) error {
+	if f.maxTotalBytes <= 0 {
+		return nil
+	}
+	f.allocated += int64(n)
+	if f.allocated > f.maxTotalBytes {
+		return ErrMaxTotalBytesExceeded
+	}
+	return nil
+}
+
+// shouldBeNil consumes a byte and reports whether a nillable value should be
+// left nil according to nilChance. The chance of allocating is halved for
+// recursive values.
+func (f *ConsumeFuzzer) shouldBeNil(recursive bool) (bool, error) {
+	randByte, err := f.controlSource().GetByte()
+	if err != nil {
+		return false, err
+	}
+	chance := f.nilChance
+	if f.depthScaledNilChance {
+		chance += (1 - chance) * float32(f.curDepth) / float32(f.maxDepth)
+	}
+	if recursive {
+		chance = (1 + chance) / 2
+	}
+	isNil := float32(randByte%10) < chance*10
+	if isNil && f.logger != nil {
+		f.logger("%s: left nil", f.pathString())
+	}
+	return isNil, nil
+}
+
+// sliceLen returns the number of elements to generate for a slice of type t.
+func (f *ConsumeFuzzer) sliceLen(t reflect.Type) (int, error) {
+	// Byte slices, including named ones, have their own limit.
+	if t.Elem().Kind() != reflect.Uint8 {
+		return f.collectionLen(f.scaledMax(f.maxSliceElements), f.controlSource().GetPositiveInt)
+	}
+	// Their length is encoded like the length of a string, so that long
+	// slices can be encoded.
+	n, err := f.collectionLen(f.maxByteSliceLen, func() (int, error) {
+		length, err := f.controlSource().GetLength()
+		return int(length), err
+	})
+	if err != nil {
+		return 0, err
+	}
+	// Every byte is read from the source, so never allocate more than what
+	// is left.
+	if remaining := f.source.Remaining(); uint64(n) > uint64(remaining) {
+		if remaining < f.minSliceElements {
+			return 0, fmt.Errorf("min slice elements %d greater than the %d bytes left: %w", f.minSliceElements, remaining, bytesource.ErrNotEnoughBytes)
+		}
+		n = int(remaining)
+	}
+	return n, nil
+}
+
+// collectionLen returns a length between the minimum number of slice
+// elements and maxElements, reduced from the length read by getLen.
+func (f *ConsumeFuzzer) collectionLen(maxElements uint32, getLen func() (int, error)) (int, error) {
+	if f.boundaryCollectionSizes {
+		n, ok, err := f.boundaryLen(f.minSliceElements, maxElements)
+		if err != nil || ok {
+			return n, err
+		}
+	}
+
+	randQty, err := getLen()
+	if err != nil {
+		return 0, err
+	}
+	numOfElements := f.minSliceElements
+	if maxElements > f.minSliceElements {
+		numOfElements += uint32(randQty) % (maxElements - f.minSliceElements)
+	}
+	return int(numOfElements), nil
+}
+
+// scaledMax returns the exclusive maximum number of elements of a
+// collection at the current depth: max at the top level, halved at each
+// level below it when collections are depth scaled. Scaled collections can
+// always hold one element.
+func (f *ConsumeFuzzer) scaledMax(max uint32) uint32 {
+	if !f.depthScaledCollections || f.curDepth <= 1 || max <= 2 {
+		return max
+	}
+	if f.curDepth > 32 {
+		return 2
+	}
+	if scaled := max >> uint(f.curDepth-1); scaled > 2 {
+		return scaled
+	}
+	return 2
+}
+
+// mapLen returns the number of entries to generate for a map.
+func (f *ConsumeFuzzer) mapLen() (int, error) {
+	maxElements := f.scaledMax(50)
+	if f.boundaryCollectionSizes {
+		n, ok, err := f.boundaryLen(0, maxElements)
+		if err != nil || ok {
+			return n, err
+		}
+	}
+
+	randQty, err := f.controlSource().GetPositiveInt()
+	if err != nil {
+		return 0, err
+	}
+	return randQty % int(maxElements), nil
+}
+
+// boundarySizes are collection sizes likely to trigger off-by-one and
+// resizing bugs.
+var boundarySizes = []uint32{
+	0, 1, 2, 3, 4, 7, 8, 9, 15, 16, 17, 31, 32, 33, 63, 64, 65,
+	127, 128, 129, 255, 256, 257, 511, 512, 513, 1023, 1024, 1025,
+}
+
+// boundaryLen consumes a byte and, half of the time, picks a size in
+// [min, max) from boundarySizes. ok is false when a regular size should be
+// generated instead.
+func (f *ConsumeFuzzer) boundaryLen(min, max uint32) (n int, ok bool, err error) {
+	b, err := f.controlSource().GetByte()
+	if err != nil {
+		return 0, false, err
+	}
+	if b%2 != 0 {
+		return 0, false, nil
+	}
+
+	var sizes []uint32
+	for _, size := range boundarySizes {
+		if size >= min && size < max {
+			sizes = append(sizes, size)
+		}
+	}
+	if len(sizes) == 0 {
+		return 0, false, nil
+	}
+	i, err := f.controlSource().GetChoiceIndex(len(sizes))
+	if err != nil {
+		return 0, false, err
+	}
+	return int(sizes[i]), true, nil
+}
+
+// customTarget returns the value to pass to the custom function registered
+// for e, if any. Functions are looked up by the exact, possibly named, type:
+// *T for pointer functions and M for functions taking a map by value. A
+// value that is not addressable cannot be passed to a pointer function and
+// is generated without it.
+func (f *ConsumeFuzzer) customTarget(e reflect.Value) (reflect.Value, bool) {
+	if f.disallowCustomFuncs || !e.IsValid() {
+		return reflect.Value{}, false
+	}
+	if e.CanAddr() && f.hasCustomFunction(e.Addr()) {
+		return e.Addr(), true
+	}
+	if e.Kind() == reflect.Map && f.hasCustomFunction(e) {
+		return e, true
+	}
+	return reflect.Value{}, false
+}
+
 func (f *ConsumeFuzzer) hasCustomFunction(v reflect.Value) bool {
 	_, ok := f.customFuncs[v.Type()]
 	return ok
 }
+
+func (f *ConsumeFuzzer) isBlocked(t reflect.Type) bool {
+	_, ok := f.blockedTypes[t]
+	return ok
+}
+
+// hasHandlerForType reports whether values of type t are generated by a
+// registered handler rather than from their kind: an enum, a type
+// replacement, interesting values, a string corpus, or a custom or kind
+// function.
+func (f *ConsumeFuzzer) hasHandlerForType(t reflect.Type) bool {
+	if _, ok := f.enumValues[t]; ok {
+		return true
+	}
+	if _, ok := f.typeReplacements[t]; ok {
+		return true
+	}
+	if _, ok := f.interestingValues[t]; ok {
+		return true
+	}
+	if _, ok := f.stringCorpora[t]; ok {
+		return true
+	}
+	// Only custom and kind functions are disabled by
+	// WithoutCustomFuncs.
+	if f.disallowCustomFuncs {
+		return false
+	}
+	if _, ok := f.customFuncs[reflect.PtrTo(t)]; ok {
+		return true
+	}
+	_, ok := f.kindFuncs[t.Kind()]
+	return ok
+}
@@ -0,0 +1,2372 @@
+// Copyright 2023 The go-fuzz-headers Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gofuzzheaders_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+
+	gofuzzheaders "github.com/kruskall/go-fuzz-headers"
+	"github.com/kruskall/go-fuzz-headers/bytesource"
+)
+
+// tryGenerate generates a, reporting false if the input was too short.
+func tryGenerate(t *testing.T, fuzzer *gofuzzheaders.ConsumeFuzzer, a any) bool {
+	t.Helper()
+
+	if err := fuzzer.GenerateStruct(a); err != nil {
+		if errors.Is(err, bytesource.ErrNotEnoughBytes) {
+			return false
+		}
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+	return true
+}
+
+func TestMinSliceElements(t *testing.T) {
+	for qty := 0; qty < 256; qty += 7 {
+		input := make([]byte, 64)
+		input[1] = byte(qty)
+
+		c := gofuzzheaders.NewConsumer(input,
+			gofuzzheaders.WithNilChance(0),
+			gofuzzheaders.WithMinSliceElements(5),
+			gofuzzheaders.WithMaxSliceElements(10),
+		)
+
+		s := struct{ I []int }{}
+
+		if err := c.GenerateStruct(&s); err != nil {
+			t.Fatalf("failed to generate struct: %v", err)
+		}
+		if len(s.I) < 5 || len(s.I) >= 10 {
+			t.Errorf("qty %d: got %d elements, want [5, 10)", qty, len(s.I))
+		}
+	}
+}
+
+func TestMinSliceElementsGreaterThanMax(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic when min slice elements is greater than max")
+		}
+	}()
+	gofuzzheaders.NewConsumer(nil,
+		gofuzzheaders.WithMinSliceElements(10),
+		gofuzzheaders.WithMaxSliceElements(5),
+	)
+}
+
+func TestMinSliceElementsEqualToMax(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic when min slice elements is equal to the exclusive max")
+		}
+	}()
+	gofuzzheaders.NewConsumer(nil,
+		gofuzzheaders.WithMinSliceElements(5),
+		gofuzzheaders.WithMaxSliceElements(5),
+	)
+}
+
+type forcedError struct{}
+
+func TestGenerateErrorPath(t *testing.T) {
+	errForced := errors.New("forced")
+	calls := 0
+
+	c := gofuzzheaders.NewConsumer([]byte{0x00, 0x05},
+		gofuzzheaders.WithNilChance(0),
+		gofuzzheaders.WithCustomFunction(func(v *forcedError, c gofuzzheaders.Continue) error {
+			calls++
+			if calls == 4 {
+				return errForced
+			}
+			return nil
+		}),
+	)
+
+	s := struct {
+		Foo struct {
+			Bar []struct {
+				Baz forcedError
+			}
+		}
+	}{}
+
+	err := c.GenerateStruct(&s)
+
+	var genErr *gofuzzheaders.GenerateError
+	if !errors.As(err, &genErr) {
+		t.Fatalf("expected a GenerateError, got %v", err)
+	}
+	if genErr.Path != "Foo.Bar[3].Baz" {
+		t.Errorf("got path %q, want %q", genErr.Path, "Foo.Bar[3].Baz")
+	}
+	if genErr.Offset != 2 {
+		t.Errorf("got offset %d, want 2", genErr.Offset)
+	}
+	if !errors.Is(err, errForced) {
+		t.Errorf("expected error to wrap the custom function error")
+	}
+}
+
+func TestMutate(t *testing.T) {
+	type inner struct {
+		D int
+		E int
+	}
+	s := struct {
+		A string
+		B int
+		C []int
+		N inner
+	}{
+		A: "foo",
+		B: 1,
+		C: []int{1, 2, 3},
+		N: inner{D: 4, E: 5},
+	}
+
+	c := gofuzzheaders.NewConsumer([]byte{0x07, 0x08})
+	if err := c.Mutate(&s, "B", "N.E"); err != nil {
+		t.Fatalf("failed to mutate struct: %v", err)
+	}
+
+	if s.B != 7 || s.N.E != 8 {
+		t.Errorf("mutated fields were not regenerated: B=%d, N.E=%d", s.B, s.N.E)
+	}
+	if s.A != "foo" || len(s.C) != 3 || s.N.D != 4 {
+		t.Errorf("fields outside the mutation set changed: %+v", s)
+	}
+
+	if err := c.Mutate(&s, "Missing"); err == nil {
+		t.Errorf("expected error when mutating a missing field")
+	}
+}
+
+func TestMaxTotalBytes(t *testing.T) {
+	input := make([]byte, 512)
+	input[1] = 200 // byte slice length
+
+	c := gofuzzheaders.NewConsumer(input,
+		gofuzzheaders.WithNilChance(0),
+		gofuzzheaders.WithMaxTotalBytes(10),
+	)
+
+	s := struct {
+		B []byte
+		I int
+	}{}
+
+	err := c.GenerateStruct(&s)
+	if !errors.Is(err, gofuzzheaders.ErrMaxTotalBytesExceeded) {
+		t.Fatalf("expected ErrMaxTotalBytesExceeded, got %v", err)
+	}
+	if s.B != nil {
+		t.Errorf("expected generation to stop before allocating, got %d bytes", len(s.B))
+	}
+}
+
+func TestMultiLevelPointers(t *testing.T) {
+	type leaf struct {
+		A int
+	}
+	s := struct {
+		Two   **int
+		Three ***leaf
+	}{}
+
+	c := gofuzzheaders.NewConsumer(make([]byte, 16), gofuzzheaders.WithNilChance(0))
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	if s.Two == nil || *s.Two == nil {
+		t.Errorf("expected **int to be populated to the leaf")
+	}
+	if s.Three == nil || *s.Three == nil || **s.Three == nil {
+		t.Errorf("expected ***struct to be populated to the leaf")
+	}
+}
+
+func TestMultiLevelPointersNilChance(t *testing.T) {
+	s := struct {
+		Two **int
+	}{}
+
+	// The first level is allocated, the second one is left nil.
+	c := gofuzzheaders.NewConsumer([]byte{0x09, 0x00}, gofuzzheaders.WithNilChance(0.5))
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	if s.Two == nil {
+		t.Fatalf("expected first level to be allocated")
+	}
+	if *s.Two != nil {
+		t.Errorf("expected second level to be nil")
+	}
+}
+
+func TestGenerateOverwritesNonZeroValues(t *testing.T) {
+	s := struct {
+		S string
+		I int
+		U uint16
+		F float64
+		B bool
+	}{"foo", 1, 2, 3, false}
+
+	c := gofuzzheaders.NewConsumer(make([]byte, 64))
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	// A zero byte decodes to true for bools.
+	if s.S != "" || s.I != 0 || s.U != 0 || s.F != 0 || !s.B {
+		t.Errorf("expected generated values to overwrite existing ones, got %+v", s)
+	}
+}
+
+func BenchmarkGenerateStruct(b *testing.B) {
+	type inner struct {
+		A int
+		B uint32
+		C float64
+		D string
+	}
+	input := make([]byte, 4096)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s := struct {
+			I  inner
+			P  *inner
+			S  []inner
+			M  map[string]int
+			Bs []byte
+		}{}
+		c := gofuzzheaders.NewConsumer(input)
+		_ = c.GenerateStruct(&s)
+	}
+}
+
+func TestKindFunction(t *testing.T) {
+	type name string
+	dictionary := []string{"alpha", "beta", "gamma"}
+
+	c := gofuzzheaders.NewConsumer(make([]byte, 256),
+		gofuzzheaders.WithNilChance(0),
+		gofuzzheaders.WithKindFunction(reflect.String, func(c gofuzzheaders.Continue) (reflect.Value, error) {
+			i, err := c.Source.GetInt()
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			return reflect.ValueOf(dictionary[i%len(dictionary)]), nil
+		}),
+	)
+
+	s := struct {
+		A string
+		B name
+		C struct {
+			D string
+		}
+		E []string
+	}{}
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	values := append([]string{s.A, string(s.B), s.C.D}, s.E...)
+	for _, v := range values {
+		if v != "alpha" && v != "beta" && v != "gamma" {
+			t.Errorf("string %q does not come from the dictionary", v)
+		}
+	}
+}
+
+type customA struct{ V string }
+type customB struct{ V string }
+type customC struct{ V string }
+
+func TestCustomFunctionsForElements(t *testing.T) {
+	c := gofuzzheaders.NewConsumer(bytes.Repeat([]byte{0x05}, 256),
+		gofuzzheaders.WithCustomFunction(func(a *customA, c gofuzzheaders.Continue) error {
+			a.V = "custom"
+			return nil
+		}),
+	)
+
+	s := struct {
+		M  map[string]customA
+		MP map[string]*customA
+		S  []customA
+		A  [2]customA
+	}{}
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+	if len(s.M) == 0 || len(s.MP) == 0 || len(s.S) == 0 {
+		t.Fatalf("expected non-empty collections, got %+v", s)
+	}
+	for _, a := range s.M {
+		if a.V != "custom" {
+			t.Errorf("custom function was not used for a map value: %+v", a)
+		}
+	}
+	for _, a := range s.MP {
+		if a != nil && a.V != "custom" {
+			t.Errorf("custom function was not used for a map pointer value: %+v", a)
+		}
+	}
+	for _, a := range append(s.S, s.A[:]...) {
+		if a.V != "custom" {
+			t.Errorf("custom function was not used for an element: %+v", a)
+		}
+	}
+
+	// Values that are not addressable cannot be passed to a custom function
+	// and are rejected instead of panicking.
+	c = gofuzzheaders.NewConsumer(nil,
+		gofuzzheaders.WithCustomFunction(func(a *customB, c gofuzzheaders.Continue) error {
+			return c.Fuzz(reflect.ValueOf(customA{}))
+		}),
+	)
+	if err := c.GenerateStruct(&customB{}); err == nil {
+		t.Errorf("expected an error for a value that is not addressable")
+	}
+}
+
+func TestCustomFunctions(t *testing.T) {
+	c := gofuzzheaders.NewConsumer(nil,
+		gofuzzheaders.WithCustomFunctions(
+			func(a *customA, c gofuzzheaders.Continue) error {
+				a.V = "a"
+				return nil
+			},
+			func(b *customB, c gofuzzheaders.Continue) error {
+				b.V = "b"
+				return nil
+			},
+			func(cc *customC, c gofuzzheaders.Continue) error {
+				cc.V = "c"
+				return nil
+			},
+		),
+	)
+
+	s := struct {
+		A customA
+		B customB
+		C customC
+	}{}
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	if s.A.V != "a" || s.B.V != "b" || s.C.V != "c" {
+		t.Errorf("custom functions were not all used: %+v", s)
+	}
+}
+
+func TestMaxMapKeyAttempts(t *testing.T) {
+	input := make([]byte, 256)
+	input[1] = 10 // map size
+	for i := 2; i < len(input); i++ {
+		input[i] = byte(i)
+	}
+
+	c := gofuzzheaders.NewConsumer(input,
+		gofuzzheaders.WithNilChance(0),
+		gofuzzheaders.WithMaxMapKeyAttempts(3),
+	)
+
+	s := struct {
+		M map[bool]int
+	}{}
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+	if len(s.M) == 0 || len(s.M) > 2 {
+		t.Errorf("expected at most 2 entries, got %d", len(s.M))
+	}
+}
+
+func TestCustomFunctionSliceAndArray(t *testing.T) {
+	c := gofuzzheaders.NewConsumer(nil,
+		gofuzzheaders.WithCustomFunctions(
+			func(s *[]customA, c gofuzzheaders.Continue) error {
+				*s = append(*s, customA{V: "slice"})
+				return nil
+			},
+			func(a *[2]customB, c gofuzzheaders.Continue) error {
+				a[1].V = "array"
+				return nil
+			},
+		),
+	)
+
+	s := struct {
+		S []customA
+		A [2]customB
+	}{}
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	if len(s.S) != 1 || s.S[0].V != "slice" {
+		t.Errorf("slice custom function was not used: %+v", s.S)
+	}
+	if s.A[1].V != "array" {
+		t.Errorf("array custom function was not used: %+v", s.A)
+	}
+}
+
+func TestArray(t *testing.T) {
+	c := gofuzzheaders.NewConsumer([]byte{0x01, 0x02, 0x03})
+
+	s := struct {
+		A [3]uint8
+	}{}
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+	if s.A != [3]uint8{1, 2, 3} {
+		t.Errorf("got %v, want [1 2 3]", s.A)
+	}
+}
+
+type state uint8
+
+const (
+	stateIdle state = iota + 1
+	stateRunning
+	stateDone
+)
+
+func TestEnumValues(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		c := gofuzzheaders.NewConsumer([]byte{byte(i), byte(i + 1)},
+			gofuzzheaders.WithEnumValues(reflect.TypeOf(state(0)), []interface{}{stateIdle, stateRunning, stateDone}),
+		)
+
+		s := struct {
+			S     state
+			Other uint8
+		}{}
+		if err := c.GenerateStruct(&s); err != nil {
+			t.Fatalf("failed to generate struct: %v", err)
+		}
+
+		if s.S != stateIdle && s.S != stateRunning && s.S != stateDone {
+			t.Fatalf("got invalid state %d", s.S)
+		}
+		if s.Other != byte(i+1) {
+			t.Fatalf("non-enum field was not generated normally")
+		}
+	}
+}
+
+func TestEnumValuesWithoutCustomFuncs(t *testing.T) {
+	c := gofuzzheaders.NewConsumer([]byte{0x01, 0x00},
+		gofuzzheaders.WithoutCustomFuncs(),
+		gofuzzheaders.WithEnumValues(reflect.TypeOf(state(0)), []interface{}{42}),
+	)
+	s := struct {
+		A int
+		S state
+	}{}
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+	if s.S != 42 {
+		t.Errorf("got state %d, want the enum value 42", s.S)
+	}
+}
+
+func TestBoundaryCollectionSizes(t *testing.T) {
+	boundary := map[int]bool{0: true, 1: true, 2: true, 3: true, 4: true, 7: true, 8: true, 9: true, 15: true, 16: true, 17: true}
+
+	r := rand.New(rand.NewSource(1))
+	const runs = 1000
+	hits := 0
+	for i := 0; i < runs; i++ {
+		input := make([]byte, 256)
+		r.Read(input)
+
+		c := gofuzzheaders.NewConsumer(input,
+			gofuzzheaders.WithNilChance(0),
+			gofuzzheaders.WithMaxSliceElements(20),
+			gofuzzheaders.WithBoundaryCollectionSizes(),
+		)
+		s := struct {
+			S []int
+		}{}
+		generate(t, c, &s)
+
+		if boundary[len(s.S)] {
+			hits++
+		}
+	}
+
+	// Half of the sizes are boundary sizes, plus the ones hit by chance
+	// with a uniform size.
+	if rate := float64(hits) / runs; rate < 0.5 {
+		t.Errorf("got boundary size rate %.3f, want at least 0.5", rate)
+	}
+}
+
+func TestContinueGetStringFrom(t *testing.T) {
+	const charset = "abc"
+	input := make([]byte, 64)
+	rand.New(rand.NewSource(1)).Read(input)
+
+	c := gofuzzheaders.NewConsumer(input,
+		gofuzzheaders.WithCustomFunction(func(a *customA, c gofuzzheaders.Continue) error {
+			s, err := c.GetStringFrom(charset, 10)
+			a.V = s
+			return err
+		}),
+	)
+
+	s := struct {
+		A customA
+	}{}
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	if len(s.A.V) != 10 || strings.Trim(s.A.V, charset) != "" {
+		t.Errorf("%q is not a 10 characters string from %q", s.A.V, charset)
+	}
+}
+
+type (
+	namedID      string
+	namedIDs     []namedID
+	namedHeaders map[string][]string
+	namedFlags   uint32
+)
+
+func TestNamedTypesCustomFunctions(t *testing.T) {
+	c := gofuzzheaders.NewConsumer([]byte{0x01, 0x02, 0x03, 0x04},
+		gofuzzheaders.WithCustomFunctions(
+			func(id *namedID, c gofuzzheaders.Continue) error {
+				*id = "id"
+				return nil
+			},
+			func(ids *namedIDs, c gofuzzheaders.Continue) error {
+				*ids = namedIDs{"a", "b"}
+				return nil
+			},
+			func(h namedHeaders, c gofuzzheaders.Continue) error {
+				h["Key"] = []string{"value"}
+				return nil
+			},
+		),
+	)
+
+	s := struct {
+		ID      namedID
+		IDs     namedIDs
+		Headers namedHeaders
+		Flags   namedFlags
+	}{}
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	if s.ID != "id" {
+		t.Errorf("named string custom function was not used: %q", s.ID)
+	}
+	if len(s.IDs) != 2 {
+		t.Errorf("named slice custom function was not used: %v", s.IDs)
+	}
+	if s.Headers["Key"] == nil {
+		t.Errorf("named map custom function was not used: %v", s.Headers)
+	}
+	if s.Flags != 0x04030201 {
+		t.Errorf("named uint32 was not generated: %d", s.Flags)
+	}
+}
+
+func TestHandlerPrecedence(t *testing.T) {
+	input := bytes.Repeat([]byte{0x05}, 64)
+	kindFuncs := 0
+	kindFunc := func(c gofuzzheaders.Continue) (reflect.Value, error) {
+		kindFuncs++
+		return reflect.Value{}, nil
+	}
+
+	// An exact type custom function wins over a kind function.
+	s := struct {
+		ID   namedID
+		Name string
+	}{}
+	c := gofuzzheaders.NewConsumer(input,
+		gofuzzheaders.WithKindFunction(reflect.String, func(c gofuzzheaders.Continue) (reflect.Value, error) {
+			return reflect.ValueOf("kind"), nil
+		}),
+		gofuzzheaders.WithCustomFunction(func(id *namedID, c gofuzzheaders.Continue) error {
+			*id = "custom"
+			return nil
+		}),
+	)
+	if err := c.Generate(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+	if s.ID != "custom" || s.Name != "kind" {
+		t.Errorf("got %+v, want the custom function for ID and the kind function for Name", s)
+	}
+
+	// Interface implementations win over a kind function.
+	var sh shape
+	c = gofuzzheaders.NewConsumer(input,
+		gofuzzheaders.WithKindFunction(reflect.Interface, kindFunc),
+		gofuzzheaders.WithInterfaceImplementations(shapeType, reflect.TypeOf(square{})),
+	)
+	if err := c.Generate(&sh); err != nil {
+		t.Fatalf("failed to generate interface: %v", err)
+	}
+	if _, ok := sh.(square); !ok || kindFuncs != 0 {
+		t.Errorf("got %#v after %d kind function calls, want a square", sh, kindFuncs)
+	}
+
+	// A kind function wins over a built-in handler.
+	b := big.NewInt(42)
+	c = gofuzzheaders.NewConsumer(input,
+		gofuzzheaders.WithBigNumberSupport(),
+		gofuzzheaders.WithKindFunction(reflect.Struct, kindFunc),
+	)
+	if err := c.Generate(b); err != nil {
+		t.Fatalf("failed to generate big.Int: %v", err)
+	}
+	if kindFuncs != 1 || b.Sign() != 0 {
+		t.Errorf("got %s after %d kind function calls, want the kind function to set 0", b, kindFuncs)
+	}
+
+	// A custom function registered after a built-in handler replaces it.
+	c = gofuzzheaders.NewConsumer(input,
+		gofuzzheaders.WithBigNumberSupport(),
+		gofuzzheaders.WithKindFunction(reflect.Struct, kindFunc),
+		gofuzzheaders.WithCustomFunction(func(i *big.Int, c gofuzzheaders.Continue) error {
+			i.SetInt64(7)
+			return nil
+		}),
+	)
+	if err := c.Generate(b); err != nil {
+		t.Fatalf("failed to generate big.Int: %v", err)
+	}
+	if kindFuncs != 1 || b.Int64() != 7 {
+		t.Errorf("got %s after %d kind function calls, want the custom function to set 7", b, kindFuncs)
+	}
+}
+
+func TestFallbackRandom(t *testing.T) {
+	type target struct {
+		A int
+		B string
+		C []uint16
+		D *float64
+		E map[string]bool
+	}
+	gen := func(seed int64) target {
+		c := gofuzzheaders.NewConsumer([]byte{0x01, 0x02},
+			gofuzzheaders.WithNilChance(0),
+			gofuzzheaders.WithFallbackRandom(seed),
+		)
+		var s target
+		if err := c.GenerateStruct(&s); err != nil {
+			t.Fatalf("failed to generate struct: %v", err)
+		}
+		return s
+	}
+
+	s := gen(1)
+	if s.A != 1 {
+		t.Errorf("expected input bytes to be consumed first, got A=%d", s.A)
+	}
+	if s.D == nil || s.E == nil {
+		t.Errorf("expected a fully populated struct, got %+v", s)
+	}
+	if !reflect.DeepEqual(s, gen(1)) {
+		t.Errorf("expected the same seed to generate the same struct")
+	}
+}
+
+func TestFillZeroOnly(t *testing.T) {
+	type inner struct {
+		X, Y int
+	}
+	s := struct {
+		A int
+		B int
+		S string
+		P *inner
+		N inner
+	}{
+		A: 42,
+		P: &inner{X: 7},
+		N: inner{Y: 9},
+	}
+
+	input := make([]byte, 64)
+	for i := range input {
+		input[i] = 0x01
+	}
+	c := gofuzzheaders.NewConsumer(input, gofuzzheaders.WithFillZeroOnly())
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	if s.A != 42 || s.P.X != 7 || s.N.Y != 9 {
+		t.Errorf("non-zero values were overwritten: %+v %+v", s, *s.P)
+	}
+	if s.B == 0 || s.S == "" || s.P.Y == 0 || s.N.X == 0 {
+		t.Errorf("zero values were not filled: %+v %+v", s, *s.P)
+	}
+}
+
+type foreignFields struct {
+	secret int
+	R      bytes.Reader
+	big.Int
+}
+
+func TestUnexportedAllowedPackages(t *testing.T) {
+	input := bytes.Repeat([]byte{0x05}, 256)
+	generate := func(opts ...gofuzzheaders.Option) foreignFields {
+		s := foreignFields{}
+		c := gofuzzheaders.NewConsumer(input,
+			append(opts, gofuzzheaders.WithUnexportedFieldStrategy(gofuzzheaders.KeepFuzzing))...)
+		if err := c.GenerateStruct(&s); err != nil {
+			t.Fatalf("failed to generate struct: %v", err)
+		}
+		return s
+	}
+
+	s := generate(gofuzzheaders.WithUnexportedAllowedPackages(reflect.TypeOf(foreignFields{}).PkgPath()))
+	if s.secret == 0 {
+		t.Errorf("expected the unexported field of an allowed package to be fuzzed")
+	}
+	if s.R.Size() != 0 || s.Int.Sign() != 0 {
+		t.Errorf("expected the unexported fields of other packages to be left untouched, got size %d and %s", s.R.Size(), &s.Int)
+	}
+
+	s = generate()
+	if s.secret == 0 || s.R.Size() == 0 {
+		t.Errorf("expected every unexported field to be fuzzed without an allow list")
+	}
+}
+
+type lockedCounter struct {
+	sync.Mutex
+	A    int
+	rw   sync.RWMutex
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+func TestSyncTypesLeftZero(t *testing.T) {
+	input := bytes.Repeat([]byte{0xff}, 256)
+	c := gofuzzheaders.NewConsumer(input, gofuzzheaders.WithUnexportedFieldStrategy(gofuzzheaders.KeepFuzzing))
+	s := lockedCounter{}
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+	if s.A == 0 {
+		t.Errorf("expected A to be generated")
+	}
+
+	s.Lock()
+	s.A++
+	s.Unlock()
+	s.rw.RLock()
+	s.rw.RUnlock()
+	s.rw.Lock()
+	s.rw.Unlock()
+	s.wg.Add(1)
+	s.wg.Done()
+	s.wg.Wait()
+	called := false
+	s.once.Do(func() { called = true })
+	if !called {
+		t.Errorf("expected the sync.Once to be unused")
+	}
+}
+
+func TestGenerateTargets(t *testing.T) {
+	input := []byte{0x05, 0x02, 0x07, 0x08, 0x09, 0x0a}
+
+	var i int
+	if err := gofuzzheaders.NewConsumer(input).Generate(&i); err != nil || i != 5 {
+		t.Errorf("got int %d, %v, want 5", i, err)
+	}
+	var str string
+	if err := gofuzzheaders.NewConsumer(input[1:]).Generate(&str); err != nil || str != "\x07\x08" {
+		t.Errorf("got string %q, %v, want %q", str, err, "\x07\x08")
+	}
+	var slice []uint8
+	if err := gofuzzheaders.NewConsumer(input).Generate(&slice); err != nil || !bytes.Equal(slice, []byte{0x07, 0x08}) {
+		t.Errorf("got slice %v, %v, want [7 8]", slice, err)
+	}
+	var m map[uint8]uint8
+	if err := gofuzzheaders.NewConsumer(input).Generate(&m); err != nil || !reflect.DeepEqual(m, map[uint8]uint8{0x07: 0x08, 0x09: 0x0a}) {
+		t.Errorf("got map %v, %v, want map[7:8 9:10]", m, err)
+	}
+	var p *int
+	if err := gofuzzheaders.NewConsumer(input).Generate(&p); err != nil || p == nil || *p != 2 {
+		t.Errorf("got pointer %v, %v, want a pointer to 2", p, err)
+	}
+
+	// GenerateStruct is not limited to structs.
+	i = 0
+	if err := gofuzzheaders.NewConsumer(input).GenerateStruct(&i); err != nil || i != 5 {
+		t.Errorf("got int %d, %v, want 5", i, err)
+	}
+}
+
+func TestGenerateValue(t *testing.T) {
+	types := []reflect.Type{
+		reflect.TypeOf(0),
+		reflect.TypeOf(""),
+		reflect.TypeOf([]uint8{}),
+		reflect.TypeOf(map[string]int{}),
+		reflect.TypeOf(replayStruct{}),
+		reflect.TypeOf(&replayStruct{}),
+	}
+	c := gofuzzheaders.NewConsumer(bytes.Repeat([]byte{0x05}, 256))
+	for _, typ := range types {
+		v, err := c.GenerateValue(typ)
+		if err != nil {
+			t.Fatalf("failed to generate a %s: %v", typ, err)
+		}
+		if v.Type() != typ {
+			t.Errorf("got a %s, want a %s", v.Type(), typ)
+		}
+		if !v.CanSet() || v.IsZero() {
+			t.Errorf("expected a settable, generated %s, got %#v", typ, v)
+		}
+	}
+
+	if _, err := c.GenerateValue(nil); err == nil {
+		t.Errorf("expected an error for a nil type")
+	}
+	if _, err := c.GenerateValue(reflect.TypeOf(complex64(0))); err == nil {
+		t.Errorf("expected an error for an unsupported type")
+	}
+}
+
+func TestGenerateUnsupportedTargets(t *testing.T) {
+	for _, target := range []interface{}{
+		new(func()),
+		new(chan int),
+		new(complex128),
+		new(uintptr),
+		new(fmt.Stringer),
+	} {
+		c := gofuzzheaders.NewConsumer(make([]byte, 64))
+		if err := c.Generate(target); err == nil {
+			t.Errorf("expected an error for a %T target", target)
+		}
+	}
+
+	c := gofuzzheaders.NewConsumer(make([]byte, 64),
+		gofuzzheaders.WithStubFuncFields(),
+		gofuzzheaders.WithChannelSupport(),
+		gofuzzheaders.WithCustomFunction(func(c *complex128, _ gofuzzheaders.Continue) error {
+			*c = 1i
+			return nil
+		}),
+	)
+	fn, ch, cplx := new(func()), new(chan int), new(complex128)
+	for _, target := range []interface{}{fn, ch, cplx} {
+		if err := c.Generate(target); err != nil {
+			t.Errorf("failed to generate a %T target: %v", target, err)
+		}
+	}
+	if *fn == nil || *ch == nil || *cplx != 1i {
+		t.Errorf("targets were not generated")
+	}
+}
+
+func TestGenerateStructContext(t *testing.T) {
+	input := bytes.Repeat([]byte{0x05}, 64)
+	type target struct {
+		A int
+		S []string
+	}
+
+	want := target{}
+	if err := gofuzzheaders.NewConsumer(input).GenerateStruct(&want); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+	got := target{}
+	if err := gofuzzheaders.NewConsumer(input).GenerateStructContext(context.Background(), &got); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c := gofuzzheaders.NewConsumer(nil, gofuzzheaders.WithFallbackRandom(1))
+	var s [][]byte
+	if err := c.GenerateStructContext(ctx, &s); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	if s != nil {
+		t.Errorf("expected nothing to be generated, got %d slices", len(s))
+	}
+
+	// The context only applies to the GenerateStructContext call.
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Errorf("failed to generate struct after a canceled context: %v", err)
+	}
+}
+
+type cancelElem int
+
+func TestGenerateStructContextCancelledInSlice(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calls := 0
+	c := gofuzzheaders.NewConsumer(make([]byte, 64),
+		gofuzzheaders.WithNilChance(0),
+		gofuzzheaders.WithMinSliceElements(20),
+		gofuzzheaders.WithMaxSliceElements(21),
+		gofuzzheaders.WithCustomFunctions(func(e *cancelElem, c gofuzzheaders.Continue) error {
+			calls++
+			if calls == 15 {
+				cancel()
+			}
+			return nil
+		}),
+	)
+	var s []cancelElem
+	if err := c.GenerateStructContext(ctx, &s); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	if s != nil {
+		t.Errorf("expected the slice not to be set, got %d elements", len(s))
+	}
+}
+
+func TestControlSource(t *testing.T) {
+	type target struct {
+		P *int
+		S []int
+		A int
+	}
+	generate := func(control, data []byte) target {
+		s := target{}
+		c := gofuzzheaders.NewConsumer(data,
+			gofuzzheaders.WithControlSource(bytesource.New(control, 0)))
+		if err := c.GenerateStruct(&s); err != nil {
+			t.Fatalf("failed to generate struct: %v", err)
+		}
+		return s
+	}
+
+	s := generate([]byte{0x05, 0x05, 0x03}, []byte{0x01, 0x02, 0x03, 0x04, 0x05})
+	if s.P == nil || *s.P != 1 || !reflect.DeepEqual(s.S, []int{2, 3, 4}) || s.A != 5 {
+		t.Errorf("unexpected value %+v", s)
+	}
+
+	// Changing the data changes the contents but not the shape.
+	s = generate([]byte{0x05, 0x05, 0x03}, []byte{0x09, 0x08, 0x07, 0x06, 0x05})
+	if s.P == nil || *s.P != 9 || !reflect.DeepEqual(s.S, []int{8, 7, 6}) || s.A != 5 {
+		t.Errorf("unexpected value %+v", s)
+	}
+
+	// Changing the structural decisions keeps the data in order.
+	s = generate([]byte{0x00, 0x05, 0x01}, []byte{0x01, 0x02, 0x03, 0x04, 0x05})
+	if s.P != nil || !reflect.DeepEqual(s.S, []int{1}) || s.A != 2 {
+		t.Errorf("unexpected value %+v", s)
+	}
+}
+
+func TestPreserveNonZeroFields(t *testing.T) {
+	type inner struct {
+		X, Y int
+	}
+	s := struct {
+		A int
+		B int
+		S string
+		P *inner
+		N inner
+		Z inner
+	}{
+		A: 42,
+		P: &inner{X: 7},
+		N: inner{Y: 9},
+	}
+
+	input := make([]byte, 64)
+	for i := range input {
+		input[i] = 0x01
+	}
+	c := gofuzzheaders.NewConsumer(input, gofuzzheaders.WithPreserveNonZeroFields())
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	if s.A != 42 || *s.P != (inner{X: 7}) || s.N != (inner{Y: 9}) {
+		t.Errorf("non-zero fields were modified: %+v %+v", s, *s.P)
+	}
+	if s.B == 0 || s.S == "" || s.Z.X == 0 || s.Z.Y == 0 {
+		t.Errorf("zero fields were not generated: %+v", s)
+	}
+}
+
+func TestPartialOnExhaustion(t *testing.T) {
+	s := struct {
+		A int
+		B int
+		C string
+		D int
+	}{}
+
+	c := gofuzzheaders.NewConsumer([]byte{0x01, 0x02}, gofuzzheaders.WithPartialOnExhaustion())
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if s.A != 1 || s.B != 2 {
+		t.Errorf("expected leading fields to be set, got %+v", s)
+	}
+	if s.C != "" || s.D != 0 {
+		t.Errorf("expected trailing fields to be zero, got %+v", s)
+	}
+}
+
+type listNode struct {
+	Next *listNode
+	Val  int
+}
+
+func TestDepthScaledNilChance(t *testing.T) {
+	const levels = 4
+	var reached, nils [levels]int
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		input := make([]byte, 512)
+		r.Read(input)
+
+		c := gofuzzheaders.NewConsumer(input,
+			gofuzzheaders.WithMaxDepth(20),
+			gofuzzheaders.WithDepthScaledNilChance(0.1),
+		)
+		var head listNode
+		if err := c.GenerateStruct(&head); err != nil {
+			t.Fatalf("failed to generate struct: %v", err)
+		}
+
+		node := &head
+		for level := 0; level < levels && node != nil; level++ {
+			reached[level]++
+			if node.Next == nil {
+				nils[level]++
+			}
+			node = node.Next
+		}
+	}
+
+	first := float64(nils[0]) / float64(reached[0])
+	last := float64(nils[levels-1]) / float64(reached[levels-1])
+	if last <= first {
+		t.Errorf("expected deeper nodes to be nil more often: level 0 %.2f, level %d %.2f", first, levels-1, last)
+	}
+}
+
+func TestCollectionLengthsAreClamped(t *testing.T) {
+	input := make([]byte, 4096)
+	for i := range input {
+		input[i] = 0xff
+	}
+
+	c := gofuzzheaders.NewConsumer(input,
+		gofuzzheaders.WithNilChance(0),
+		gofuzzheaders.WithMaxSliceElements(20),
+	)
+	s := struct {
+		S []uint16
+		M map[uint8]uint8
+	}{}
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	if len(s.S) >= 20 {
+		t.Errorf("slice length %d is not clamped to 20", len(s.S))
+	}
+	if len(s.M) >= 50 {
+		t.Errorf("map length %d is not clamped to 50", len(s.M))
+	}
+}
+
+func TestRecursiveTypeTerminates(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	const runs = 1000
+	total, longest := 0, 0
+	for i := 0; i < runs; i++ {
+		input := make([]byte, 512)
+		r.Read(input)
+
+		c := gofuzzheaders.NewConsumer(input)
+		var head *listNode
+		if err := c.GenerateStruct(&head); err != nil {
+			t.Fatalf("failed to generate struct: %v", err)
+		}
+
+		length := 0
+		for node := head; node != nil; node = node.Next {
+			length++
+		}
+		total += length
+		if length > longest {
+			longest = length
+		}
+	}
+
+	if mean := float64(total) / runs; mean > 2 {
+		t.Errorf("got mean list length %.2f, want at most 2", mean)
+	}
+	if longest < 3 {
+		t.Errorf("got longest list length %d, want some longer lists", longest)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	type node struct {
+		Next *node
+	}
+	type logged struct {
+		A *int
+		B node
+		C string
+		D uint64
+	}
+
+	var lines []string
+	c := gofuzzheaders.NewConsumer([]byte{0x00, 0x09},
+		gofuzzheaders.WithMaxDepth(3),
+		gofuzzheaders.WithCustomFunction(func(s *string, c gofuzzheaders.Continue) error {
+			*s = "custom"
+			return nil
+		}),
+		gofuzzheaders.WithLogger(func(format string, args ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, args...))
+		}),
+	)
+
+	s := logged{}
+	if err := c.GenerateStruct(&s); err == nil {
+		t.Fatalf("expected the source to be exhausted")
+	}
+	want := []string{
+		"A: left nil",
+		"B.Next: max depth 3 exceeded",
+		"C: calling custom function for *string",
+		"D: source exhausted at offset 2",
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("got log lines %q, want %q", lines, want)
+	}
+}
+
+func TestFieldHook(t *testing.T) {
+	var calls []string
+	c := gofuzzheaders.NewConsumer([]byte{0x01, 0x00, 0x02, 0x03, 0x04},
+		gofuzzheaders.WithNilChance(0),
+		gofuzzheaders.WithFieldHook(func(path string, v reflect.Value) {
+			calls = append(calls, fmt.Sprintf("%s=%v", path, v.Interface()))
+		}),
+	)
+
+	s := struct {
+		A int
+		B []struct {
+			C uint8
+		}
+	}{}
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	want := []string{"A=1", "B[0].C=3", "B[1].C=4", "B=[{3} {4}]"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("got hook calls %q, want %q", calls, want)
+	}
+}
+
+type embeddedBase struct {
+	ID string
+}
+
+type embeddingOuter struct {
+	embeddedBase
+	Name string
+}
+
+func TestCustomFuncInheritance(t *testing.T) {
+	baseFunc := gofuzzheaders.WithCustomFunction(func(b *embeddedBase, c gofuzzheaders.Continue) error {
+		b.ID = "base"
+		return nil
+	})
+
+	for _, inherit := range []bool{false, true} {
+		opts := []gofuzzheaders.Option{baseFunc}
+		if inherit {
+			opts = append(opts, gofuzzheaders.WithCustomFuncInheritance())
+		}
+		c := gofuzzheaders.NewConsumer([]byte{0x01, 'a', 0x01, 'b'}, opts...)
+
+		var s embeddingOuter
+		if err := c.GenerateStruct(&s); err != nil {
+			t.Fatalf("failed to generate struct: %v", err)
+		}
+
+		// Without inheritance the promoted ID field is generated as any
+		// other field.
+		wantID, wantName := "a", "b"
+		if inherit {
+			wantID, wantName = "base", "a"
+		}
+		if s.ID != wantID {
+			t.Errorf("inherit=%t: got embedded ID %q, want %q", inherit, s.ID, wantID)
+		}
+		if s.Name != wantName {
+			t.Errorf("inherit=%t: got outer Name %q, want %q", inherit, s.Name, wantName)
+		}
+	}
+}
+
+type replayStruct struct {
+	A int
+	S string
+}
+
+func TestReplay(t *testing.T) {
+	v, steps, err := gofuzzheaders.Replay([]byte{0x07, 0x02, 'h', 'i'}, replayStruct{})
+	if err != nil {
+		t.Fatalf("failed to replay: %v", err)
+	}
+
+	if want := (replayStruct{A: 7, S: "hi"}); v != want {
+		t.Errorf("got value %+v, want %+v", v, want)
+	}
+	want := []gofuzzheaders.DecodeStep{
+		{Path: "A", Offset: 0, Size: 1, Value: 7},
+		{Path: "S", Offset: 1, Size: 3, Value: "hi"},
+	}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("got decode log %+v, want %+v", steps, want)
+	}
+}
+
+func TestReplayNilSample(t *testing.T) {
+	if _, _, err := gofuzzheaders.Replay([]byte{0x07}, nil); err == nil {
+		t.Error("expected an error for a nil sample")
+	}
+}
+
+type version struct {
+	Major, Minor int
+}
+
+func (v version) String() string {
+	return fmt.Sprintf("v%d.%d", v.Major, v.Minor)
+}
+
+func (v *version) UnmarshalText(b []byte) error {
+	_, err := fmt.Sscanf(string(b), "v%d.%d", &v.Major, &v.Minor)
+	return err
+}
+
+type color int
+
+func (c color) String() string {
+	return strconv.Itoa(int(c))
+}
+
+func TestStringCorpus(t *testing.T) {
+	c := gofuzzheaders.NewConsumer([]byte{0x01, 0x02},
+		gofuzzheaders.WithStringCorpus(reflect.TypeOf(version{}), []string{"v1.0", "v2.5"}),
+		gofuzzheaders.WithStringCorpus(reflect.TypeOf(color(0)), []string{"1", "2", "3"}),
+	)
+
+	s := struct {
+		V version
+		C color
+	}{}
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	if s.V.String() != "v2.5" {
+		t.Errorf("got version %s, want v2.5", s.V)
+	}
+	if s.C != 3 {
+		t.Errorf("got color %d, want 3", s.C)
+	}
+}
+
+func TestStringCorpusRequiresStringer(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a type not implementing fmt.Stringer")
+		}
+	}()
+	gofuzzheaders.WithStringCorpus(reflect.TypeOf(0), []string{"1"})
+}
+
+type blob []byte
+
+func TestNamedByteSliceIsNotRestricted(t *testing.T) {
+	input := make([]byte, 512)
+	input[1] = 200 // byte slice length
+
+	c := gofuzzheaders.NewConsumer(input, gofuzzheaders.WithNilChance(0))
+
+	s := struct {
+		B blob
+	}{}
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	if len(s.B) != 200 {
+		t.Errorf("expected a named byte slice of 200 bytes, got %d", len(s.B))
+	}
+}
+
+func TestMaxByteSliceLen(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		input := make([]byte, r.Intn(64))
+		r.Read(input)
+
+		c := gofuzzheaders.NewConsumer(input,
+			gofuzzheaders.WithNilChance(0),
+			gofuzzheaders.WithMaxByteSliceLen(16),
+		)
+		s := struct {
+			B []byte
+		}{}
+		if !tryGenerate(t, c, &s) {
+			continue
+		}
+
+		if len(s.B) > 16 || len(s.B) > len(input) {
+			t.Fatalf("got %d bytes from %d input bytes, want at most 16", len(s.B), len(input))
+		}
+	}
+}
+
+func TestByteSliceLenIsBoundedByInput(t *testing.T) {
+	c := gofuzzheaders.NewConsumer([]byte{0x00, 0xfe, 0x01, 0x02}, gofuzzheaders.WithNilChance(0))
+	s := struct {
+		B []byte
+	}{}
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	if want := []byte{0x01, 0x02}; !bytes.Equal(s.B, want) {
+		t.Errorf("got %v, want the remaining input %v", s.B, want)
+	}
+}
+
+func TestByteSliceMinLenIsNotBoundedByInput(t *testing.T) {
+	c := gofuzzheaders.NewConsumer([]byte{0x00, 0xfe, 0x01, 0x02},
+		gofuzzheaders.WithNilChance(0),
+		gofuzzheaders.WithMinSliceElements(5),
+	)
+	s := struct {
+		B []byte
+	}{}
+	if err := c.GenerateStruct(&s); !errors.Is(err, bytesource.ErrNotEnoughBytes) {
+		t.Errorf("got %v, want an error wrapping %v", err, bytesource.ErrNotEnoughBytes)
+	}
+}
+
+func TestByteSliceEscapedLength(t *testing.T) {
+	long := bytes.Repeat([]byte{'x'}, 300)
+	c := gofuzzheaders.NewConsumer(append([]byte{0x00, 0xff, 0x2c, 0x01, 0x00, 0x00}, long...),
+		gofuzzheaders.WithNilChance(0),
+	)
+	s := struct {
+		B []byte
+	}{}
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	if !bytes.Equal(s.B, long) {
+		t.Errorf("got %d bytes, want the %d bytes of the escaped length", len(s.B), len(long))
+	}
+}
+
+func TestGenerateMap(t *testing.T) {
+	c := gofuzzheaders.NewConsumer([]byte{
+		0x05,            // not nil
+		0x02,            // two entries
+		0x01, 'a', 0x07, // "a": 7
+		0x01, 'b', 0x08, // "b": 8
+	})
+
+	var m map[string]int
+	if err := c.GenerateMap(&m); err != nil {
+		t.Fatalf("failed to generate map: %v", err)
+	}
+
+	if want := map[string]int{"a": 7, "b": 8}; !reflect.DeepEqual(m, want) {
+		t.Errorf("got %v, want %v", m, want)
+	}
+
+	var notAMap []int
+	if err := c.GenerateMap(&notAMap); err == nil {
+		t.Error("expected an error for a pointer to a slice")
+	}
+}
+
+func TestBlockedTypes(t *testing.T) {
+	type secret int
+	s := struct {
+		Secret secret
+		Lock   *sync.Mutex
+		Other  int
+	}{}
+
+	c := gofuzzheaders.NewConsumer([]byte{0x07},
+		gofuzzheaders.WithBlockedTypes(gofuzzheaders.IgnoreValue, reflect.TypeOf(secret(0)), reflect.TypeOf(&sync.Mutex{})),
+	)
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+	if s.Secret != 0 || s.Lock != nil {
+		t.Errorf("expected blocked fields to be left zero, got %d and %v", s.Secret, s.Lock)
+	}
+	if s.Other != 7 {
+		t.Errorf("expected the sibling to be generated, got %d", s.Other)
+	}
+
+	c = gofuzzheaders.NewConsumer([]byte{0x07},
+		gofuzzheaders.WithBlockedTypes(gofuzzheaders.FailWithError, reflect.TypeOf(&sync.Mutex{})),
+	)
+	if err := c.GenerateStruct(&s); err == nil {
+		t.Error("expected an error for a blocked type")
+	}
+}
+
+type EmbeddedExported struct {
+	A int
+}
+
+type embeddedUnexported struct {
+	B      int
+	hidden int
+}
+
+type embeddingAll struct {
+	EmbeddedExported
+	embeddedUnexported
+	*EmbeddedPtr
+	C int
+}
+
+type EmbeddedPtr struct {
+	D int
+}
+
+func TestEmbeddedStructs(t *testing.T) {
+	c := gofuzzheaders.NewConsumer([]byte{0x01, 0x02, 0x05, 0x03, 0x04}, gofuzzheaders.WithNilChance(0))
+
+	var s embeddingAll
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	if s.A != 1 || s.B != 2 || s.EmbeddedPtr == nil || s.D != 3 || s.C != 4 {
+		t.Errorf("expected every promoted field to be generated, got %+v and %+v", s, s.EmbeddedPtr)
+	}
+	if s.hidden != 0 {
+		t.Errorf("expected the unexported field to be ignored, got %d", s.hidden)
+	}
+}
+
+func TestGenerateStructConsumed(t *testing.T) {
+	c := gofuzzheaders.NewConsumer([]byte{0x07, 0x02, 'h', 'i', 0x08, 0x00, 0xff})
+
+	var s replayStruct
+	n, err := c.GenerateStructConsumed(&s)
+	if err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+	if n != 4 {
+		t.Errorf("got %d bytes consumed, want 4", n)
+	}
+
+	// The count is relative to the position before the call.
+	n, err = c.GenerateStructConsumed(&s)
+	if err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("got %d bytes consumed by the second call, want 2", n)
+	}
+}
+
+func TestUnconsumed(t *testing.T) {
+	c := gofuzzheaders.NewConsumer([]byte{0x07, 0x02, 'h', 'i', 0x08, 0x00, 0xff})
+
+	var s replayStruct
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+	if got := c.Unconsumed(); !bytes.Equal(got, []byte{0x08, 0x00, 0xff}) {
+		t.Errorf("got unconsumed bytes %v, want [8 0 255]", got)
+	}
+
+	// A recorder exposes the bytes of the source it wraps.
+	c = gofuzzheaders.NewConsumer(nil, gofuzzheaders.WithSource(bytesource.NewRecorder(bytesource.New([]byte{0x07, 0x00, 0x01}, 100))))
+	if err := c.GenerateStruct(new(int)); err != nil {
+		t.Fatalf("failed to generate int: %v", err)
+	}
+	if got := c.Unconsumed(); !bytes.Equal(got, []byte{0x00, 0x01}) {
+		t.Errorf("got unconsumed bytes %v through a recorder, want [0 1]", got)
+	}
+}
+
+func TestErrorValues(t *testing.T) {
+	errA := errors.New("a")
+	errB := fmt.Errorf("b: %w", errA)
+
+	seen := make(map[error]bool)
+	for i := 0; i < 3; i++ {
+		c := gofuzzheaders.NewConsumer([]byte{byte(i), 0x07}, gofuzzheaders.WithErrorValues(errA, errB))
+		s := struct {
+			Err   error
+			Other int
+		}{}
+		if err := c.GenerateStruct(&s); err != nil {
+			t.Fatalf("failed to generate struct: %v", err)
+		}
+		if s.Other != 7 {
+			t.Errorf("expected the error choice to consume a single byte, got Other=%d", s.Other)
+		}
+		seen[s.Err] = true
+	}
+
+	if !seen[nil] || !seen[errA] || !seen[errB] {
+		t.Errorf("expected nil and every registered error, got %v", seen)
+	}
+}
+
+func TestBigNumberSupport(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		input := make([]byte, 1024)
+		r.Read(input)
+
+		c := gofuzzheaders.NewConsumer(input,
+			gofuzzheaders.WithNilChance(0),
+			gofuzzheaders.WithBigNumberSupport(),
+		)
+		s := struct {
+			I *big.Int
+			F big.Float
+		}{}
+		if !tryGenerate(t, c, &s) {
+			continue
+		}
+
+		parsed, ok := new(big.Int).SetString(s.I.String(), 10)
+		if !ok || parsed.Cmp(s.I) != 0 {
+			t.Fatalf("generated big.Int %s does not round-trip", s.I)
+		}
+		if s.F.IsInf() {
+			t.Fatalf("generated big.Float is infinite")
+		}
+		if _, ok := new(big.Float).SetString(s.F.Text('g', -1)); !ok {
+			t.Fatalf("generated big.Float %s cannot be parsed", s.F.Text('g', -1))
+		}
+	}
+}
+
+func TestGenerateStructRejectsNonPointers(t *testing.T) {
+	c := gofuzzheaders.NewConsumer([]byte{0x01, 0x02},
+		gofuzzheaders.WithUnexportedFieldStrategy(gofuzzheaders.KeepFuzzing),
+	)
+
+	var nilPtr *replayStruct
+	tests := []struct {
+		target interface{}
+		want   string
+	}{
+		{nil, "target is nil"},
+		{replayStruct{}, "target must be a pointer, got gofuzzheaders_test.replayStruct"},
+		{nilPtr, "target is a nil *gofuzzheaders_test.replayStruct"},
+	}
+	for _, tt := range tests {
+		if err := c.GenerateStruct(tt.target); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("got error %v for target %#v, want it to contain %q", err, tt.target, tt.want)
+		}
+		if err := c.Mutate(tt.target, "A"); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("got Mutate error %v for target %#v, want it to contain %q", err, tt.target, tt.want)
+		}
+	}
+}
+
+type depthLeaf struct {
+	C int
+}
+
+type depthMiddle struct {
+	B    int
+	Leaf depthLeaf
+}
+
+type depthRoot struct {
+	A      int
+	Middle depthMiddle
+}
+
+func TestDepthExceededStrategy(t *testing.T) {
+	input := []byte{0x01, 0x02, 0x03, 0x04}
+
+	c := gofuzzheaders.NewConsumer(input, gofuzzheaders.WithMaxDepth(2))
+	var s depthRoot
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+	if s.A != 1 || s.Middle.B != 0 {
+		t.Errorf("expected generation to silently stop at the max depth, got %+v", s)
+	}
+
+	c = gofuzzheaders.NewConsumer(input,
+		gofuzzheaders.WithMaxDepth(2),
+		gofuzzheaders.WithDepthExceededStrategy(gofuzzheaders.FailWithError),
+	)
+	var genErr *gofuzzheaders.GenerateError
+	if err := c.GenerateStruct(&s); !errors.As(err, &genErr) || genErr.Path != "Middle.B" {
+		t.Errorf("expected a depth error at Middle.B, got %v", err)
+	}
+}
+
+func TestMaxDepthMustBePositive(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a max depth of 0")
+		}
+	}()
+	gofuzzheaders.NewConsumer(nil, gofuzzheaders.WithMaxDepth(0))
+}
+
+func TestMaxMapKeyAttemptsMustBePositive(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for zero max map key attempts")
+		}
+	}()
+	gofuzzheaders.NewConsumer(nil, gofuzzheaders.WithMaxMapKeyAttempts(0))
+}
+
+func TestNetworkTypeSupport(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	generated := 0
+	for i := 0; i < 50; i++ {
+		input := make([]byte, 256)
+		r.Read(input)
+
+		c := gofuzzheaders.NewConsumer(input,
+			gofuzzheaders.WithNilChance(0),
+			gofuzzheaders.WithNetworkTypeSupport(),
+		)
+		s := struct {
+			U  *url.URL
+			IP net.IP
+		}{}
+		if !tryGenerate(t, c, &s) {
+			continue
+		}
+		generated++
+
+		parsed, err := url.Parse(s.U.String())
+		if err != nil {
+			t.Fatalf("generated URL %s does not parse: %v", s.U, err)
+		}
+		if !reflect.DeepEqual(parsed, s.U) {
+			t.Fatalf("generated URL %#v re-parses to %#v", s.U, parsed)
+		}
+		if !net.ParseIP(s.IP.String()).Equal(s.IP) {
+			t.Fatalf("generated IP %v does not round-trip", []byte(s.IP))
+		}
+	}
+	if generated == 0 {
+		t.Error("no value was generated")
+	}
+}
+
+func TestHTTPTypeSupport(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	generated := 0
+	for i := 0; i < 50; i++ {
+		input := make([]byte, 512)
+		r.Read(input)
+
+		c := gofuzzheaders.NewConsumer(input,
+			gofuzzheaders.WithNilChance(0),
+			gofuzzheaders.WithHTTPTypeSupport(),
+		)
+		s := struct {
+			H http.Header
+			V url.Values
+		}{}
+		if !tryGenerate(t, c, &s) {
+			continue
+		}
+		if len(s.H) > 0 && len(s.V) > 0 {
+			generated++
+		}
+
+		for name, values := range s.H {
+			if name != http.CanonicalHeaderKey(name) {
+				t.Errorf("header name %q is not canonical", name)
+			}
+			if len(values) == 0 {
+				t.Errorf("header %q has no value", name)
+			}
+			for _, v := range values {
+				if v == "" || strings.ContainsAny(v, "\r\n") {
+					t.Errorf("header %q has invalid value %q", name, v)
+				}
+			}
+		}
+		parsed, err := url.ParseQuery(s.V.Encode())
+		if err != nil || !reflect.DeepEqual(parsed, s.V) {
+			t.Errorf("values %v do not round-trip: %v, %v", s.V, parsed, err)
+		}
+	}
+	if generated < 10 {
+		t.Errorf("only %d inputs generated headers and values", generated)
+	}
+}
+
+func TestInterestingValues(t *testing.T) {
+	interesting := []interface{}{0, -1, math.MaxInt64}
+	seen := make(map[int]int)
+	for i := 0; i < 256; i++ {
+		c := gofuzzheaders.NewConsumer([]byte{byte(i), 0x05},
+			gofuzzheaders.WithInterestingValues(reflect.TypeOf(0), interesting),
+		)
+		s := struct {
+			A int
+		}{}
+		if err := c.GenerateStruct(&s); err != nil {
+			t.Fatalf("failed to generate struct: %v", err)
+		}
+		seen[s.A]++
+	}
+
+	for _, v := range []int{-1, math.MaxInt64} {
+		if seen[v] == 0 {
+			t.Errorf("expected interesting value %d to be generated", v)
+		}
+	}
+	if seen[5] != 192 {
+		t.Errorf("expected the other values to be fuzzed normally, got %d fuzzed values", seen[5])
+	}
+}
+
+// constSource is a fake Source generating the same int and string forever.
+type constSource struct {
+	bytesource.Source
+	reads int
+}
+
+func (s *constSource) GetInt() (int, error) {
+	s.reads++
+	return 42, nil
+}
+
+func (s *constSource) GetString() (string, error) {
+	s.reads++
+	return "fake", nil
+}
+
+func (s *constSource) Position() uint32 {
+	return uint32(s.reads)
+}
+
+func TestWithSource(t *testing.T) {
+	src := &constSource{}
+	c := gofuzzheaders.NewConsumer(nil, gofuzzheaders.WithSource(src))
+
+	var s replayStruct
+	n, err := c.GenerateStructConsumed(&s)
+	if err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+	if s.A != 42 || s.S != "fake" || n != 2 {
+		t.Errorf("expected the values of the fake source, got %+v after %d reads", s, n)
+	}
+}
+
+func TestWithReaderSource(t *testing.T) {
+	input := []byte{0x07, 0x02, 'h', 'i'}
+	c := gofuzzheaders.NewConsumer(nil,
+		gofuzzheaders.WithSource(bytesource.NewFromReader(bytes.NewReader(input), 100)),
+	)
+
+	var s replayStruct
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+	if want := (replayStruct{A: 7, S: "hi"}); s != want {
+		t.Errorf("got %+v, want %+v", s, want)
+	}
+}
+
+func TestReaderSourceError(t *testing.T) {
+	errRead := errors.New("read failed")
+	r := io.MultiReader(bytes.NewReader([]byte{0x07}), iotest.ErrReader(errRead))
+	c := gofuzzheaders.NewConsumer(nil,
+		gofuzzheaders.WithSource(bytesource.NewFromReader(r, 100)),
+		gofuzzheaders.WithPartialOnExhaustion(),
+	)
+
+	var s replayStruct
+	if err := c.GenerateStruct(&s); !errors.Is(err, errRead) {
+		t.Errorf("got %v, want the reader error", err)
+	}
+
+	// A recorder reports the errors of the source it wraps.
+	r = io.MultiReader(bytes.NewReader([]byte{0x07}), iotest.ErrReader(errRead))
+	c = gofuzzheaders.NewConsumer(nil,
+		gofuzzheaders.WithSource(bytesource.NewRecorder(bytesource.NewFromReader(r, 100))),
+		gofuzzheaders.WithPartialOnExhaustion(),
+	)
+	if err := c.GenerateStruct(&s); !errors.Is(err, errRead) {
+		t.Errorf("got %v, want the reader error through a recorder", err)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	rec := bytesource.NewRecorder(bytesource.New([]byte{0x07, 0x02, 'h', 'i'}, 100))
+	c := gofuzzheaders.NewConsumer(nil, gofuzzheaders.WithSource(rec))
+
+	var s replayStruct
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	want := []bytesource.Call{
+		{Method: "GetInt", Offset: 0, Size: 1, Value: 7},
+		{Method: "GetString", Offset: 1, Size: 3, Value: "hi"},
+	}
+	if got := rec.Trace(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got trace %+v, want %+v", got, want)
+	}
+}
+
+func TestContinueSource(t *testing.T) {
+	var sources []*bytesource.ByteSource
+	custom := gofuzzheaders.WithCustomFunction(func(u *uint32, c gofuzzheaders.Continue) error {
+		sources = append(sources, c.Source)
+		v, err := c.Decoder().GetUint32With(binary.BigEndian)
+		*u = v
+		return err
+	})
+	input := []byte{0x00, 0x00, 0x01, 0x02}
+
+	var u uint32
+	c := gofuzzheaders.NewConsumer(input, custom)
+	if err := c.GenerateStruct(&u); err != nil || u != 0x0102 {
+		t.Errorf("got %#x, %v, want 0x102", u, err)
+	}
+
+	rec := bytesource.NewRecorder(bytesource.New(input, 100))
+	c = gofuzzheaders.NewConsumer(nil, gofuzzheaders.WithSource(rec), custom)
+	if err := c.GenerateStruct(&u); err != nil || u != 0x0102 {
+		t.Errorf("got %#x, %v, want 0x102 from the recorder", u, err)
+	}
+	if len(rec.Trace()) != 1 || rec.Trace()[0].Method != "GetUint32With" {
+		t.Errorf("expected the recorder to trace the call, got %+v", rec.Trace())
+	}
+
+	if len(sources) != 2 || sources[0] == nil || sources[1] != nil {
+		t.Errorf("expected Source to be set only for a byte source, got %v", sources)
+	}
+}
+
+func TestWithEndianness(t *testing.T) {
+	input := []byte{0x3f, 0xf0, 0, 0, 0, 0, 0, 0}
+	c := gofuzzheaders.NewConsumer(input, gofuzzheaders.WithEndianness(binary.BigEndian))
+
+	s := struct {
+		F float64
+	}{}
+	n, err := c.GenerateStructConsumed(&s)
+	if err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+	if n != 8 {
+		t.Errorf("expected no byte order byte to be read, got %d bytes consumed", n)
+	}
+	if s.F != 1 {
+		t.Errorf("got %v, want 1 decoded as big endian", s.F)
+	}
+}
+
+func TestConcurrentClones(t *testing.T) {
+	template := gofuzzheaders.NewConsumer(nil,
+		gofuzzheaders.WithNilChance(0),
+		gofuzzheaders.WithCustomFunction(func(s *string, c gofuzzheaders.Continue) error {
+			n, err := c.Source.GetInt()
+			*s = strconv.Itoa(n)
+			return err
+		}),
+		gofuzzheaders.WithEnumValues(reflect.TypeOf(uint8(0)), []interface{}{1, 2, 3}),
+	)
+
+	type target struct {
+		A int
+		S string
+		E uint8
+		M map[string][]int
+		P *target
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for j := 0; j < 50; j++ {
+				input := make([]byte, 512)
+				r.Read(input)
+				var s target
+				// Errors are expected for inputs too short for the value.
+				_ = template.Clone(input).GenerateStruct(&s)
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+}
+
+func TestMaxStringLen(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		input := make([]byte, 64)
+		r.Read(input)
+
+		c := gofuzzheaders.NewConsumer(input, gofuzzheaders.WithMaxStringLen(8))
+		s := struct {
+			S string
+		}{}
+		if !tryGenerate(t, c, &s) {
+			continue
+		}
+		if len(s.S) > 8 {
+			t.Fatalf("got a string of %d bytes, want at most 8", len(s.S))
+		}
+	}
+
+	c := gofuzzheaders.NewConsumer([]byte{0x09, 'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i'},
+		gofuzzheaders.WithMaxStringLen(8),
+	)
+	s := struct {
+		S string
+	}{}
+	if err := c.GenerateStruct(&s); err != nil || s.S != "abcdefgh" {
+		t.Errorf("got %q, %v, want a string clamped to 8 bytes", s.S, err)
+	}
+}
+
+func TestSourceOptionsApplyToTheInputOnly(t *testing.T) {
+	input := []byte{0x05, 'a', 'b', 'c', 'd', 'e'}
+	s := struct {
+		S string
+	}{}
+
+	c := gofuzzheaders.NewConsumer(input, gofuzzheaders.WithMaxStringLen(4))
+	if err := c.GenerateStruct(&s); err != nil || s.S != "abcd" {
+		t.Errorf("got %q, %v, want the max string length to apply to the input", s.S, err)
+	}
+
+	c = gofuzzheaders.NewConsumer(nil,
+		gofuzzheaders.WithMaxStringLen(4),
+		gofuzzheaders.WithSource(bytesource.New(input, 100)),
+	)
+	if err := c.GenerateStruct(&s); err != nil || s.S != "abcde" {
+		t.Errorf("expected a WithSource source to be used as is, got %q, %v", s.S, err)
+	}
+}
+
+func TestStubFuncFields(t *testing.T) {
+	c := gofuzzheaders.NewConsumer([]byte{0x01}, gofuzzheaders.WithStubFuncFields())
+	s := struct {
+		A        int
+		Callback func(int) error
+		Format   func(string, ...interface{}) (string, int)
+	}{}
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	if s.Callback == nil || s.Format == nil {
+		t.Fatal("expected the func fields to be stubbed")
+	}
+	if err := s.Callback(1); err != nil {
+		t.Errorf("expected a nil error from the stub, got %v", err)
+	}
+	if str, n := s.Format("%d", 1); str != "" || n != 0 {
+		t.Errorf("expected zero values from the stub, got %q and %d", str, n)
+	}
+}
+
+func TestChannelSupport(t *testing.T) {
+	c := gofuzzheaders.NewConsumer([]byte{
+		0x05, 0x02, 0x07, 0x08, // chan int: capacity 5 holding 7 and 8
+		0x03, 0x00, // <-chan int: capacity 3, empty
+		0x00, 0x00, // chan<- string: unbuffered
+	}, gofuzzheaders.WithChannelSupport())
+	s := struct {
+		C    chan int
+		Recv <-chan int
+		Send chan<- string
+	}{}
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	if s.C == nil || cap(s.C) != 5 || len(s.C) != 2 {
+		t.Fatalf("expected a chan int of capacity 5 holding 2 elements, got %v", s.C)
+	}
+	if a, b := <-s.C, <-s.C; a != 7 || b != 8 {
+		t.Errorf("got elements %d and %d, want 7 and 8", a, b)
+	}
+	if s.Recv == nil || cap(s.Recv) != 3 {
+		t.Errorf("expected a receive-only chan of capacity 3, got %v", s.Recv)
+	}
+	if s.Send == nil || cap(s.Send) != 0 {
+		t.Errorf("expected an unbuffered send-only chan, got %v", s.Send)
+	}
+}
+
+func TestTypeReplacement(t *testing.T) {
+	c := gofuzzheaders.NewConsumer([]byte{0x03, 'a', 'b', 'c'},
+		gofuzzheaders.WithTypeReplacement(reflect.TypeOf((*io.Reader)(nil)).Elem(), func(c gofuzzheaders.Continue) (reflect.Value, error) {
+			b, err := c.Source.GetBytes()
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			return reflect.ValueOf(bytes.NewReader(b)), nil
+		}),
+	)
+	s := struct {
+		R io.Reader
+	}{}
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	if _, ok := s.R.(*bytes.Reader); !ok {
+		t.Fatalf("expected a *bytes.Reader, got %T", s.R)
+	}
+	b, err := io.ReadAll(s.R)
+	if err != nil || string(b) != "abc" {
+		t.Errorf("got %q, %v, want the generated bytes", b, err)
+	}
+}
+
+func TestNegativeZeroFloats(t *testing.T) {
+	input := make([]byte, 12)
+	binary.LittleEndian.PutUint64(input[0:], 0x8000000000000000) // -0
+	binary.LittleEndian.PutUint32(input[8:], 0x80000000)         // -0
+
+	c := gofuzzheaders.NewConsumer(input)
+	s := struct {
+		F64 float64
+		F32 float32
+	}{}
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	if !math.Signbit(s.F64) || !math.Signbit(float64(s.F32)) {
+		t.Errorf("expected negative zeros, got %v and %v", s.F64, s.F32)
+	}
+}
+
+func TestFiniteFloats(t *testing.T) {
+	input := make([]byte, 28)
+	binary.LittleEndian.PutUint64(input[0:], 0x7ff8000000000000)  // NaN
+	binary.LittleEndian.PutUint64(input[8:], 0x7ff0000000000000)  // +Inf
+	binary.LittleEndian.PutUint64(input[16:], 0xfff0000000000000) // -Inf
+	binary.LittleEndian.PutUint32(input[24:], 0x7fc00000)         // NaN
+
+	c := gofuzzheaders.NewConsumer(input, gofuzzheaders.WithFiniteFloats())
+	s := struct {
+		NaN, Inf, NegInf float64
+		NaN32            float32
+	}{}
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	for _, v := range []float64{s.NaN, s.Inf, s.NegInf, float64(s.NaN32)} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			t.Errorf("expected finite floats, got %+v", s)
+		}
+	}
+}
+
+func TestAsciiStrings(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	generated := 0
+	for i := 0; i < 100; i++ {
+		input := make([]byte, 512)
+		r.Read(input)
+		s := struct {
+			A string
+			B []string
+			M map[string]string
+		}{}
+		c := gofuzzheaders.NewConsumer(input, gofuzzheaders.WithAsciiStrings())
+		if err := c.GenerateStruct(&s); err != nil {
+			continue
+		}
+		strs := append([]string{s.A}, s.B...)
+		for k, v := range s.M {
+			strs = append(strs, k, v)
+		}
+		for _, str := range strs {
+			generated += len(str)
+			for _, r := range str {
+				if r < 0x20 || r > 0x7e {
+					t.Fatalf("got non printable rune %q in %q", r, str)
+				}
+			}
+		}
+	}
+	if generated == 0 {
+		t.Fatal("no strings were generated")
+	}
+}
+
+func TestShuffledFields(t *testing.T) {
+	generate := func(input []byte) (string, string) {
+		var order []string
+		c := gofuzzheaders.NewConsumer(input,
+			gofuzzheaders.WithShuffledFields(),
+			gofuzzheaders.WithFieldHook(func(path string, v reflect.Value) {
+				order = append(order, path)
+			}),
+		)
+		s := struct {
+			A, B, C int
+		}{}
+		if err := c.GenerateStruct(&s); err != nil {
+			t.Fatalf("failed to generate struct: %v", err)
+		}
+		return strings.Join(order, ","), fmt.Sprint(s)
+	}
+
+	input := []byte{0x00, 0x00, 0x01, 0x02, 0x03}
+	order, value := generate(input)
+	if order != "B,C,A" || value != "{3 1 2}" {
+		t.Errorf("got order %s and value %s, want B,C,A and {3 1 2}", order, value)
+	}
+	if order2, value2 := generate(input); order2 != order || value2 != value {
+		t.Errorf("expected the same input to generate the same order, got %s then %s", order, order2)
+	}
+	if order, _ := generate([]byte{0x02, 0x01, 0x01, 0x02, 0x03}); order != "A,B,C" {
+		t.Errorf("got order %s, want A,B,C", order)
+	}
+}
+
+type aliasNode struct {
+	Next *aliasNode
+	V    int
+}
+
+func TestPointerAliasing(t *testing.T) {
+	type pair struct {
+		A, B *int
+	}
+
+	p := pair{}
+	c := gofuzzheaders.NewConsumer([]byte{0x05, 0x07, 0x05, 0x00}, gofuzzheaders.WithPointerAliasing())
+	if err := c.GenerateStruct(&p); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+	if p.A == nil || p.A != p.B {
+		t.Errorf("expected A and B to share a pointer, got %p and %p", p.A, p.B)
+	}
+
+	p = pair{}
+	c = gofuzzheaders.NewConsumer([]byte{0x05, 0x07, 0x05, 0x01, 0x09}, gofuzzheaders.WithPointerAliasing())
+	if err := c.GenerateStruct(&p); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+	if p.A == nil || p.B == nil || p.A == p.B || *p.A != 7 || *p.B != 9 {
+		t.Errorf("expected distinct pointers to 7 and 9, got %+v", p)
+	}
+
+	n := aliasNode{}
+	c = gofuzzheaders.NewConsumer([]byte{0x09, 0x09, 0x00, 0x03, 0x04}, gofuzzheaders.WithPointerAliasing())
+	if err := c.GenerateStruct(&n); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+	if n.Next == nil || n.Next.Next != n.Next {
+		t.Fatalf("expected a cycle, got %+v", n)
+	}
+	if n.Next.V != 3 || n.V != 4 {
+		t.Errorf("got values %d and %d, want 3 and 4", n.Next.V, n.V)
+	}
+
+	// Without the option the same input allocates both pointers.
+	p = pair{}
+	c = gofuzzheaders.NewConsumer([]byte{0x05, 0x07, 0x05, 0x00})
+	if err := c.GenerateStruct(&p); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+	if p.A == p.B {
+		t.Errorf("expected distinct pointers without WithPointerAliasing")
+	}
+}
+
+func TestDepthScaledCollections(t *testing.T) {
+	// Lengths are below 50 at the top level, 25 one level below and 12 two
+	// levels below.
+	const bound = 49 * 24 * 11
+	total := func(seed int64, opts ...gofuzzheaders.Option) int {
+		var m map[string]map[string][]int
+		c := gofuzzheaders.NewConsumer(nil, append(opts, gofuzzheaders.WithFallbackRandom(seed))...)
+		if err := c.Generate(&m); err != nil {
+			t.Fatalf("failed to generate map: %v", err)
+		}
+		n := 0
+		for _, inner := range m {
+			for _, s := range inner {
+				n += len(s)
+			}
+		}
+		return n
+	}
+
+	exceeded := false
+	for seed := int64(0); seed < 20; seed++ {
+		if n := total(seed, gofuzzheaders.WithDepthScaledCollections()); n > bound {
+			t.Errorf("got %d elements with seed %d, want at most %d", n, seed, bound)
+		}
+		exceeded = exceeded || total(seed) > bound
+	}
+	if !exceeded {
+		t.Errorf("expected unscaled collections to exceed %d elements", bound)
+	}
+}
+
+func TestSliceNilPolicy(t *testing.T) {
+	nilInput := []byte{0x00}
+	emptyInput := []byte{0x05, 0x00}
+	tests := []struct {
+		policy              gofuzzheaders.SliceNilPolicy
+		nilResult, emptyNil bool
+	}{
+		{gofuzzheaders.SliceNilRandom, true, false},
+		{gofuzzheaders.SliceNilWhenEmpty, true, true},
+		{gofuzzheaders.SliceEmptyNotNil, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			generate := func(input []byte) []int {
+				s := struct{ S []int }{}
+				c := gofuzzheaders.NewConsumer(input, gofuzzheaders.WithSliceNilPolicy(tt.policy))
+				if err := c.GenerateStruct(&s); err != nil {
+					t.Fatalf("failed to generate struct: %v", err)
+				}
+				return s.S
+			}
+			if s := generate(nilInput); (s == nil) != tt.nilResult || len(s) != 0 {
+				t.Errorf("got %#v for a nil slice, want nil: %t", s, tt.nilResult)
+			}
+			if s := generate(emptyInput); (s == nil) != tt.emptyNil || len(s) != 0 {
+				t.Errorf("got %#v for an empty slice, want nil: %t", s, tt.emptyNil)
+			}
+			if s := generate([]byte{0x05, 0x01, 0x07}); len(s) != 1 || s[0] != 7 {
+				t.Errorf("got %#v, want [7]", s)
+			}
+		})
+	}
+}
+
+func TestMapKeyRetriesByDefault(t *testing.T) {
+	type smallKey uint8
+	keys := []interface{}{0, 1, 2, 3, 4, 5, 6, 7}
+
+	// averageSize returns the average size of maps whose keys take one of
+	// 8 values.
+	averageSize := func(opts ...gofuzzheaders.Option) float64 {
+		r := rand.New(rand.NewSource(1))
+		total, n := 0, 0
+		for i := 0; i < 200; i++ {
+			input := make([]byte, 256)
+			r.Read(input)
+			input[0], input[1] = 0x05, 0x08 // not nil, 8 entries
+			c := gofuzzheaders.NewConsumer(input, append(opts,
+				gofuzzheaders.WithEnumValues(reflect.TypeOf(smallKey(0)), keys))...)
+			s := struct {
+				M map[smallKey]bool
+			}{}
+			if !tryGenerate(t, c, &s) {
+				continue
+			}
+			total += len(s.M)
+			n++
+		}
+		return float64(total) / float64(n)
+	}
+
+	retried := averageSize()
+	single := averageSize(gofuzzheaders.WithMaxMapKeyAttempts(1))
+	if retried < 7 || retried <= single {
+		t.Errorf("expected retries to bring maps close to 8 entries, got %.2f with retries and %.2f without", retried, single)
+	}
+}
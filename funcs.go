@@ -15,42 +15,332 @@
 package gofuzzheaders
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
+	"strings"
 
 	"github.com/kruskall/go-fuzz-headers/bytesource"
 )
 
 type Continue struct {
+	// Source is the source of the consumer when it is a
+	// *bytesource.ByteSource, as with NewConsumer, and nil otherwise, e.g.
+	// with a Recorder set with WithSource. Decoder returns the source in
+	// every case.
 	Source *bytesource.ByteSource
+	source bytesource.Source
 	f      *ConsumeFuzzer
 }
 
+// Decoder returns the source values are decoded from, including a source
+// set with WithSource that is not a *bytesource.ByteSource.
+func (c Continue) Decoder() bytesource.Source {
+	if c.source != nil {
+		return c.source
+	}
+	if c.Source != nil {
+		return c.Source
+	}
+	return nil
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// addFuncs registers custom functions of the form func(*T, Continue) error
+// or func(M, Continue) error where M is a map type. It panics with a
+// description of the offending function if the signature is wrong.
 func (f *ConsumeFuzzer) addFuncs(fuzzFuncs []interface{}) {
 	for i := range fuzzFuncs {
 		v := reflect.ValueOf(fuzzFuncs[i])
 		if v.Kind() != reflect.Func {
-			panic("Need only funcs!")
+			panic(fmt.Sprintf("custom function must be a func, got %T", fuzzFuncs[i]))
 		}
 		t := v.Type()
 		if t.NumIn() != 2 || t.NumOut() != 1 {
-			fmt.Println(t.NumIn(), t.NumOut())
-
-			panic("Need 2 in and 1 out params. In must be the type. Out must be an error")
+			panic(fmt.Sprintf("custom function %s must take 2 parameters and return 1 value", t))
 		}
 		argT := t.In(0)
 		switch argT.Kind() {
 		case reflect.Ptr, reflect.Map:
 		default:
-			panic("fuzzFunc must take pointer or map type")
+			panic(fmt.Sprintf("custom function %s must take a pointer or map as first parameter, got %s", t, argT))
 		}
 		if t.In(1) != reflect.TypeOf(Continue{}) {
-			panic("fuzzFunc's second parameter must be type Continue")
+			panic(fmt.Sprintf("custom function %s must take Continue as second parameter, got %s", t, t.In(1)))
+		}
+		if t.Out(0) != errorType {
+			panic(fmt.Sprintf("custom function %s must return an error, got %s", t, t.Out(0)))
 		}
 		f.customFuncs[argT] = v
+		delete(f.builtinTypes, argT)
+	}
+}
+
+// addBuiltinFuncs registers the built-in custom functions fns for the types
+// that have no custom function yet.
+func (f *ConsumeFuzzer) addBuiltinFuncs(fns []interface{}) {
+	for _, fn := range fns {
+		t := reflect.TypeOf(fn).In(0)
+		if _, ok := f.customFuncs[t]; !ok {
+			f.addFuncs([]interface{}{fn})
+			f.builtinTypes[t] = true
+		}
 	}
 }
 
+// GenerateStruct generates the value pointed to by targetStruct with the
+// consumer calling the custom function, sharing its depth and limits.
 func (c Continue) GenerateStruct(targetStruct interface{}) error {
 	return c.f.GenerateStruct(targetStruct)
 }
+
+// Fuzz is like GenerateStruct for a settable reflect.Value.
+func (c Continue) Fuzz(v reflect.Value) error {
+	if !v.CanSet() {
+		return fmt.Errorf("cannot fuzz a value that is not settable: %s", v.Type())
+	}
+	return c.f.fuzzStruct(v)
+}
+
+// Depth returns the nesting depth of the value being generated, 1 for the
+// value passed to GenerateStruct.
+func (c Continue) Depth() int64 {
+	return c.f.curDepth
+}
+
+// MaxDepth returns the maximum nesting depth, see WithMaxDepth.
+func (c Continue) MaxDepth() int64 {
+	return c.f.maxDepth
+}
+
+// GetStringFrom returns a string of the given length made only of characters
+// from charset.
+func (c Continue) GetStringFrom(charset string, length int) (string, error) {
+	return c.Decoder().GetStringFrom(charset, length)
+}
+
+// GetPackedInts reads count*width bytes from the source and decodes them
+// as count little-endian signed integers of width bytes each.
+func (c Continue) GetPackedInts(count, width int) ([]int64, error) {
+	if width < 1 || width > 8 {
+		return nil, fmt.Errorf("invalid packed int width: %d", width)
+	}
+	if count < 0 {
+		return nil, fmt.Errorf("invalid packed int count: %d", count)
+	}
+	if count == 0 {
+		return []int64{}, nil
+	}
+	b, err := c.Decoder().GetNBytes(count * width)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get packed ints: %w", err)
+	}
+	shift := uint(64 - 8*width)
+	ints := make([]int64, count)
+	for i := range ints {
+		var u uint64
+		for j := width - 1; j >= 0; j-- {
+			u = u<<8 | uint64(b[i*width+j])
+		}
+		// Sign-extend from width bytes to 64 bits.
+		ints[i] = int64(u<<shift) >> shift
+	}
+	return ints, nil
+}
+
+const importPathChars = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+var importPathTLDs = []string{"com", "org", "io", "net", "dev"}
+
+// GetImportPath returns an import path like string, e.g. github.com/x/y,
+// made of a host followed by one to three lowercase path segments.
+func (c Continue) GetImportPath() (string, error) {
+	host, err := c.importPathSegment()
+	if err != nil {
+		return "", fmt.Errorf("failed to create import path: %w", err)
+	}
+	tld, err := c.Decoder().GetPositiveInt()
+	if err != nil {
+		return "", fmt.Errorf("failed to create import path: %w", err)
+	}
+	n, err := c.Decoder().GetPositiveInt()
+	if err != nil {
+		return "", fmt.Errorf("failed to create import path: %w", err)
+	}
+
+	segments := []string{host + "." + importPathTLDs[tld%len(importPathTLDs)]}
+	for i := 0; i < n%3+1; i++ {
+		segment, err := c.importPathSegment()
+		if err != nil {
+			return "", fmt.Errorf("failed to create import path: %w", err)
+		}
+		segments = append(segments, segment)
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+func (c Continue) importPathSegment() (string, error) {
+	n, err := c.Decoder().GetPositiveInt()
+	if err != nil {
+		return "", err
+	}
+	return c.Decoder().GetStringFrom(importPathChars, n%10+1)
+}
+
+// GetJSON returns a valid JSON document of nested objects, arrays, strings,
+// numbers, booleans and nulls.
+func (c Continue) GetJSON() ([]byte, error) {
+	v, err := c.jsonValue(0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create json: %w", err)
+	}
+	return json.Marshal(v)
+}
+
+func (c Continue) jsonValue(depth int) (interface{}, error) {
+	const maxDepth, maxElements = 3, 5
+
+	kinds := 6
+	if depth >= maxDepth {
+		// Only scalars past the maximum depth.
+		kinds = 4
+	}
+	kind, err := c.Decoder().GetChoiceIndex(kinds)
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case 0:
+		return nil, nil
+	case 1:
+		return c.Decoder().GetBool()
+	case 2:
+		n, err := c.Decoder().GetUint16()
+		return float64(n), err
+	case 3:
+		return c.Decoder().GetString()
+	case 4:
+		n, err := c.Decoder().GetChoiceIndex(maxElements + 1)
+		if err != nil {
+			return nil, err
+		}
+		array := make([]interface{}, n)
+		for i := range array {
+			if array[i], err = c.jsonValue(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	default:
+		n, err := c.Decoder().GetChoiceIndex(maxElements + 1)
+		if err != nil {
+			return nil, err
+		}
+		object := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			key, err := c.Decoder().GetString()
+			if err != nil {
+				return nil, err
+			}
+			if object[key], err = c.jsonValue(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return object, nil
+	}
+}
+
+var (
+	languageTagLanguages = []string{"en", "fr", "de", "es", "pt", "it", "nl", "sv", "ru", "ar", "hi", "zh", "ja", "ko", "tr", "yue"}
+	languageTagScripts   = []string{"Latn", "Cyrl", "Arab", "Hans", "Hant", "Deva"}
+	languageTagRegions   = []string{"US", "GB", "FR", "DE", "ES", "BR", "RU", "CN", "TW", "JP", "IN", "419"}
+)
+
+// GetLanguageTag returns a BCP 47 language tag made of a language and an
+// optional script and region, e.g. en-US or zh-Hant-TW.
+func (c Continue) GetLanguageTag() (string, error) {
+	parts, err := c.Decoder().GetByte()
+	if err != nil {
+		return "", fmt.Errorf("failed to create language tag: %w", err)
+	}
+
+	subtags := []struct {
+		values []string
+		use    bool
+	}{
+		{languageTagLanguages, true},
+		{languageTagScripts, parts&1 != 0},
+		{languageTagRegions, parts&2 != 0},
+	}
+
+	tag := make([]string, 0, len(subtags))
+	for _, subtag := range subtags {
+		if !subtag.use {
+			continue
+		}
+		i, err := c.Decoder().GetChoiceIndex(len(subtag.values))
+		if err != nil {
+			return "", fmt.Errorf("failed to create language tag: %w", err)
+		}
+		tag = append(tag, subtag.values[i])
+	}
+	return strings.Join(tag, "-"), nil
+}
+
+// GetHostname returns a DNS hostname made of one to three lowercase labels
+// followed by a top level domain, e.g. abc.example.com.
+func (c Continue) GetHostname() (string, error) {
+	n, err := c.Decoder().GetPositiveInt()
+	if err != nil {
+		return "", fmt.Errorf("failed to create hostname: %w", err)
+	}
+	labels := make([]string, 0, n%3+2)
+	for i := 0; i < n%3+1; i++ {
+		label, err := c.importPathSegment()
+		if err != nil {
+			return "", fmt.Errorf("failed to create hostname: %w", err)
+		}
+		labels = append(labels, label)
+	}
+	tld, err := c.Decoder().GetChoiceIndex(len(importPathTLDs))
+	if err != nil {
+		return "", fmt.Errorf("failed to create hostname: %w", err)
+	}
+	return strings.Join(append(labels, importPathTLDs[tld]), "."), nil
+}
+
+const emailLocalChars = "abcdefghijklmnopqrstuvwxyz0123456789+-_"
+
+// GetEmail returns an email address made of a local part and a hostname,
+// e.g. a+b@example.com.
+func (c Continue) GetEmail() (string, error) {
+	n, err := c.Decoder().GetPositiveInt()
+	if err != nil {
+		return "", fmt.Errorf("failed to create email: %w", err)
+	}
+	local, err := c.Decoder().GetStringFrom(emailLocalChars, n%16+1)
+	if err != nil {
+		return "", fmt.Errorf("failed to create email: %w", err)
+	}
+	host, err := c.GetHostname()
+	if err != nil {
+		return "", fmt.Errorf("failed to create email: %w", err)
+	}
+	return local + "@" + host, nil
+}
+
+// GetUUID returns a version 4 UUID in its canonical textual form, e.g.
+// 0b5e4a1c-93f2-4d7e-8a61-2f0c9d3e7b45.
+func (c Continue) GetUUID() (string, error) {
+	b, err := c.Decoder().GetNBytes(16)
+	if err != nil {
+		return "", fmt.Errorf("failed to create uuid: %w", err)
+	}
+	var u [16]byte
+	copy(u[:], b)
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16]), nil
+}
@@ -79,3 +79,125 @@ func testFuncs() []interface{} {
 	}
 }
 */
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kruskall/go-fuzz-headers/bytesource"
+)
+
+func TestContinue_GetPackedInts(t *testing.T) {
+	data := []byte{
+		0x01, 0x00, 0x00, 0x00, // 1
+		0xff, 0xff, 0xff, 0xff, // -1
+		0x00, 0x01, 0x00, 0x00, // 256
+		0x00, 0x00, 0x00, 0x80, // MinInt32
+	}
+	c := Continue{Source: bytesource.New(data, 2000000)}
+
+	ints, err := c.GetPackedInts(4, 4)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []int64{1, -1, 256, -2147483648}
+	if !reflect.DeepEqual(ints, want) {
+		t.Errorf("got %v, want %v", ints, want)
+	}
+
+	if _, err := c.GetPackedInts(1, 4); err == nil {
+		t.Errorf("expected error on exhausted source")
+	}
+	if _, err := c.GetPackedInts(1, 9); err == nil {
+		t.Errorf("expected error on invalid width")
+	}
+}
+
+func TestAddFuncsInvalidSignature(t *testing.T) {
+	tests := []struct {
+		fn   any
+		want string
+	}{
+		{"not a func", "must be a func, got string"},
+		{func(*int) error { return nil }, "func(*int) error must take 2 parameters"},
+		{func(int, Continue) error { return nil }, "must take a pointer or map as first parameter, got int"},
+		{func(*int, int) error { return nil }, "must take Continue as second parameter, got int"},
+		{func(*int, Continue) bool { return false }, "must return an error, got bool"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			defer func() {
+				msg := fmt.Sprint(recover())
+				if !strings.Contains(msg, tt.want) {
+					t.Errorf("got panic %q, want it to contain %q", msg, tt.want)
+				}
+			}()
+			NewConsumer(nil, WithCustomFunction(tt.fn))
+		})
+	}
+}
+
+type depthTree struct {
+	Child *depthTree
+}
+
+func TestContinue_Depth(t *testing.T) {
+	var depths []int64
+	c := NewConsumer(nil, WithMaxDepth(10), WithCustomFunction(func(n *depthTree, c Continue) error {
+		depths = append(depths, c.Depth())
+		// Stop recursing half way to the maximum depth.
+		if c.Depth() >= c.MaxDepth()/2 {
+			return nil
+		}
+		n.Child = &depthTree{}
+		return c.GenerateStruct(n.Child)
+	}))
+
+	var tree depthTree
+	if err := c.GenerateStruct(&tree); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	if want := []int64{1, 2, 3, 4, 5}; !reflect.DeepEqual(depths, want) {
+		t.Errorf("got depths %v, want %v", depths, want)
+	}
+}
+
+type wrapped struct {
+	Field1 string
+	Field2 string
+}
+
+type wrapper struct {
+	Kind  string
+	Inner wrapped
+	Extra int
+}
+
+func TestContinue_Delegate(t *testing.T) {
+	c := NewConsumer([]byte{0x01, 'a', 0x01, 'b', 0x07}, WithCustomFunction(func(w *wrapper, c Continue) error {
+		w.Kind = "custom"
+		if err := c.GenerateStruct(&w.Inner); err != nil {
+			return err
+		}
+		return c.Fuzz(reflect.ValueOf(w).Elem().FieldByName("Extra"))
+	}))
+
+	var w wrapper
+	if err := c.GenerateStruct(&w); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	want := wrapper{Kind: "custom", Inner: wrapped{Field1: "a", Field2: "b"}, Extra: 7}
+	if w != want {
+		t.Errorf("got %+v, want %+v", w, want)
+	}
+
+	err := Continue{f: c}.Fuzz(reflect.ValueOf(w))
+	if err == nil {
+		t.Error("expected an error for a value that is not settable")
+	}
+}
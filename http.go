@@ -0,0 +1,126 @@
+// Copyright 2023 The go-fuzz-headers Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gofuzzheaders
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// httpFuncs are the custom functions registered by WithHTTPTypeSupport.
+var httpFuncs = []interface{}{fuzzHeader, fuzzValues}
+
+const (
+	nameCharset  = "abcdefghijklmnopqrstuvwxyz"
+	valueCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-._~:/;=,"
+)
+
+var (
+	headerNames = []string{
+		"Accept", "Accept-Encoding", "Accept-Language", "Authorization", "Cache-Control",
+		"Connection", "Content-Length", "Content-Type", "Cookie", "Host", "If-None-Match",
+		"Origin", "Referer", "User-Agent", "X-Forwarded-For", "X-Request-Id",
+	}
+	headerValues = []string{
+		"*/*", "application/json", "text/html; charset=utf-8", "gzip, deflate", "en-US,en;q=0.9",
+		"Bearer token", "no-cache", "keep-alive", "close", "0", "localhost", "Mozilla/5.0",
+	}
+)
+
+// fuzzHeader adds up to 8 headers to h, each with one to three values.
+// Names are canonical and taken from common headers or made of one to
+// three dash separated words. Values are common values or printable
+// strings.
+func fuzzHeader(h http.Header, c Continue) error {
+	n, err := c.Decoder().GetIntInRange(0, 8)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		name, err := c.pickOrGenerate(headerNames, func() (string, error) {
+			words, err := c.urlSegments(1, 3, nameCharset)
+			return strings.Join(words, "-"), err
+		})
+		if err != nil {
+			return err
+		}
+		if err := c.addValues(func(v string) { h.Add(name, v) }, headerValues); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fuzzValues adds up to 8 parameters to v, each with one to three values.
+func fuzzValues(v url.Values, c Continue) error {
+	n, err := c.Decoder().GetIntInRange(0, 8)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		names, err := c.urlSegments(1, 1, nameCharset)
+		if err != nil {
+			return err
+		}
+		name := names[0]
+		if err := c.addValues(func(value string) { v.Add(name, value) }, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addValues calls add with one to three values, taken from common or made
+// of printable characters.
+func (c Continue) addValues(add func(string), common []string) error {
+	n, err := c.Decoder().GetIntInRange(1, 3)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		value, err := c.pickOrGenerate(common, func() (string, error) {
+			values, err := c.urlSegments(1, 1, valueCharset)
+			if err != nil {
+				return "", err
+			}
+			return values[0], nil
+		})
+		if err != nil {
+			return err
+		}
+		add(value)
+	}
+	return nil
+}
+
+// pickOrGenerate returns one of choices or, half of the time or if there
+// are no choices, the string returned by gen.
+func (c Continue) pickOrGenerate(choices []string, gen func() (string, error)) (string, error) {
+	if len(choices) > 0 {
+		pick, err := c.Decoder().GetBool()
+		if err != nil {
+			return "", err
+		}
+		if pick {
+			i, err := c.Decoder().GetChoiceIndex(len(choices))
+			if err != nil {
+				return "", err
+			}
+			return choices[i], nil
+		}
+	}
+	return gen()
+}
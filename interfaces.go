@@ -0,0 +1,74 @@
+// Copyright 2023 The go-fuzz-headers Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gofuzzheaders
+
+import (
+	"fmt"
+	"reflect"
+)
+
+func (f *ConsumeFuzzer) addImplementations(iface reflect.Type, impls []reflect.Type) {
+	if iface.Kind() != reflect.Interface {
+		panic(fmt.Sprintf("%s is not an interface", iface))
+	}
+	for _, impl := range impls {
+		if !impl.Implements(iface) {
+			panic(fmt.Sprintf("%s does not implement %s", impl, iface))
+		}
+	}
+	f.interfaceImpls[iface] = append(f.interfaceImpls[iface], impls...)
+}
+
+// GenerateImplementation selects one of the implementations registered for
+// iface with WithInterfaceImplementations and returns a fuzzed instance of it.
+func (f *ConsumeFuzzer) GenerateImplementation(iface reflect.Type) (interface{}, error) {
+	if iface.Kind() != reflect.Interface {
+		return nil, fmt.Errorf("%s is not an interface", iface)
+	}
+	v, err := f.generateImplementation(iface)
+	if err != nil {
+		return nil, err
+	}
+	return v.Interface(), nil
+}
+
+func (f *ConsumeFuzzer) generateImplementation(iface reflect.Type) (reflect.Value, error) {
+	impls := f.interfaceImpls[iface]
+	if len(impls) == 0 {
+		return reflect.Value{}, fmt.Errorf("no implementations registered for %s", iface)
+	}
+
+	i, err := f.controlSource().GetChoiceIndex(len(impls))
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	impl := impls[i]
+
+	// Pointer implementations are always allocated, nilChance only applies
+	// to the interface value itself.
+	if impl.Kind() == reflect.Ptr {
+		v := reflect.New(impl.Elem())
+		if err := f.fuzzStruct(v.Elem()); err != nil {
+			return reflect.Value{}, err
+		}
+		return v, nil
+	}
+
+	v := reflect.New(impl).Elem()
+	if err := f.fuzzStruct(v); err != nil {
+		return reflect.Value{}, err
+	}
+	return v, nil
+}
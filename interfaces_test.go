@@ -0,0 +1,160 @@
+// Copyright 2023 The go-fuzz-headers Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gofuzzheaders_test
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	gofuzzheaders "github.com/kruskall/go-fuzz-headers"
+)
+
+type shape interface {
+	Area() int
+}
+
+type square struct {
+	Side int
+}
+
+func (s square) Area() int { return s.Side * s.Side }
+
+type rect struct {
+	W, H int
+}
+
+func (r *rect) Area() int { return r.W * r.H }
+
+var shapeType = reflect.TypeOf((*shape)(nil)).Elem()
+
+func TestGenerateImplementation(t *testing.T) {
+	seen := make(map[reflect.Type]bool)
+	for i := 0; i < 4; i++ {
+		c := gofuzzheaders.NewConsumer([]byte{byte(i), 0x02, 0x03},
+			gofuzzheaders.WithInterfaceImplementations(shapeType,
+				reflect.TypeOf(square{}),
+				reflect.TypeOf(&rect{}),
+			),
+		)
+
+		v, err := c.GenerateImplementation(shapeType)
+		if err != nil {
+			t.Fatalf("failed to generate implementation: %v", err)
+		}
+		s, ok := v.(shape)
+		if !ok {
+			t.Fatalf("%T does not implement shape", v)
+		}
+		if s.Area() == 0 {
+			t.Errorf("expected %T to be fuzzed", v)
+		}
+		seen[reflect.TypeOf(v)] = true
+	}
+	if len(seen) != 2 {
+		t.Errorf("expected both implementations to be generated, got %v", seen)
+	}
+}
+
+func TestGenerateImplementationUnregistered(t *testing.T) {
+	c := gofuzzheaders.NewConsumer([]byte{0x00})
+	if _, err := c.GenerateImplementation(shapeType); err == nil {
+		t.Errorf("expected error for an interface without implementations")
+	}
+}
+
+func TestPointerToInterface(t *testing.T) {
+	c := gofuzzheaders.NewConsumer([]byte{0x09, 0x03},
+		gofuzzheaders.WithNilChance(0.5),
+		gofuzzheaders.WithInterfaceImplementations(shapeType, reflect.TypeOf(square{})),
+	)
+
+	s := struct {
+		Shape *shape
+	}{}
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+
+	if s.Shape == nil || *s.Shape == nil {
+		t.Fatalf("expected *shape to point at an implementation")
+	}
+	if (*s.Shape).Area() != 9 {
+		t.Errorf("got area %d, want 9", (*s.Shape).Area())
+	}
+}
+
+func TestPointerToInterfaceNil(t *testing.T) {
+	c := gofuzzheaders.NewConsumer([]byte{0x00},
+		gofuzzheaders.WithNilChance(0.5),
+		gofuzzheaders.WithInterfaceImplementations(shapeType, reflect.TypeOf(square{})),
+	)
+
+	s := struct {
+		Shape *shape
+	}{}
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+	if s.Shape != nil {
+		t.Errorf("expected nil chance to leave *shape nil")
+	}
+}
+
+type label struct {
+	Text string
+}
+
+func (l label) String() string { return l.Text }
+
+type counter struct {
+	N int
+}
+
+func (c *counter) String() string { return fmt.Sprint(c.N) }
+
+func TestSliceOfInterfaces(t *testing.T) {
+	stringerType := reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	r := rand.New(rand.NewSource(1))
+	mixed := false
+	for i := 0; i < 100 && !mixed; i++ {
+		input := make([]byte, 256)
+		r.Read(input)
+		c := gofuzzheaders.NewConsumer(input,
+			gofuzzheaders.WithInterfaceImplementations(stringerType,
+				reflect.TypeOf(label{}),
+				reflect.TypeOf(&counter{}),
+			),
+		)
+
+		s := struct {
+			Items []fmt.Stringer
+		}{}
+		if err := c.GenerateStruct(&s); err != nil {
+			continue
+		}
+		seen := make(map[reflect.Type]bool)
+		for _, item := range s.Items {
+			if item != nil {
+				seen[reflect.TypeOf(item)] = true
+			}
+		}
+		mixed = len(seen) == 2
+	}
+	if !mixed {
+		t.Errorf("expected a slice holding both implementations")
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2023 The go-fuzz-headers Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gofuzzheaders
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// networkFuncs are the custom functions registered by
+// WithNetworkTypeSupport.
+var networkFuncs = []interface{}{fuzzURL, fuzzIP}
+
+const (
+	hostCharset = "abcdefghijklmnopqrstuvwxyz0123456789"
+	pathCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-._~"
+)
+
+var urlSchemes = []string{"http", "https", "ws", "wss", "ftp"}
+
+// fuzzURL sets u to an absolute URL made of a scheme, a host of one to
+// three labels with an optional port, and a path of up to four segments.
+func fuzzURL(u *url.URL, c Continue) error {
+	i, err := c.Decoder().GetChoiceIndex(len(urlSchemes))
+	if err != nil {
+		return err
+	}
+	labels, err := c.urlSegments(1, 3, hostCharset)
+	if err != nil {
+		return err
+	}
+	host := strings.Join(labels, ".")
+	hasPort, err := c.Decoder().GetBool()
+	if err != nil {
+		return err
+	}
+	if hasPort {
+		port, err := c.Decoder().GetUint16()
+		if err != nil {
+			return err
+		}
+		host += ":" + strconv.Itoa(int(port))
+	}
+	segments, err := c.urlSegments(0, 4, pathCharset)
+	if err != nil {
+		return err
+	}
+
+	*u = url.URL{Scheme: urlSchemes[i], Host: host}
+	if len(segments) > 0 {
+		u.Path = "/" + strings.Join(segments, "/")
+	}
+	return nil
+}
+
+// urlSegments returns between min and max non-empty segments of up to 16
+// characters from charset.
+func (c Continue) urlSegments(min, max int, charset string) ([]string, error) {
+	n, err := c.Decoder().GetIntInRange(min, max)
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]string, n)
+	for i := range segments {
+		length, err := c.Decoder().GetIntInRange(1, 16)
+		if err != nil {
+			return nil, err
+		}
+		segments[i], err = c.GetStringFrom(charset, length)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return segments, nil
+}
+
+// fuzzIP sets ip to an IPv4 or IPv6 address read from 4 or 16 bytes.
+func fuzzIP(ip *net.IP, c Continue) error {
+	v4, err := c.Decoder().GetBool()
+	if err != nil {
+		return err
+	}
+	n := net.IPv6len
+	if v4 {
+		n = net.IPv4len
+	}
+	b, err := c.Decoder().GetNBytes(n)
+	if err != nil {
+		return err
+	}
+	*ip = append(net.IP(nil), b...)
+	return nil
+}
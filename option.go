@@ -1,5 +1,13 @@
 package gofuzzheaders
 
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+
+	"github.com/kruskall/go-fuzz-headers/bytesource"
+)
+
 type Option func(*ConsumeFuzzer)
 
 type HandlingStrategy byte
@@ -10,38 +18,501 @@ const (
 	FailWithError
 )
 
+func (s HandlingStrategy) String() string {
+	switch s {
+	case IgnoreValue:
+		return "IgnoreValue"
+	case KeepFuzzing:
+		return "KeepFuzzing"
+	case FailWithError:
+		return "FailWithError"
+	}
+	return fmt.Sprintf("HandlingStrategy(%d)", byte(s))
+}
+
+// SliceNilPolicy controls whether generated slices without elements are
+// nil or empty.
+type SliceNilPolicy byte
+
+const (
+	// SliceNilRandom leaves slices nil according to the nil chance and
+	// otherwise allocates them, even when they have no elements.
+	SliceNilRandom SliceNilPolicy = iota
+	// SliceNilWhenEmpty leaves every slice without elements nil.
+	SliceNilWhenEmpty
+	// SliceEmptyNotNil never leaves slices nil, slices that would have been
+	// nil are empty instead.
+	SliceEmptyNotNil
+)
+
+func (p SliceNilPolicy) String() string {
+	switch p {
+	case SliceNilRandom:
+		return "SliceNilRandom"
+	case SliceNilWhenEmpty:
+		return "SliceNilWhenEmpty"
+	case SliceEmptyNotNil:
+		return "SliceEmptyNotNil"
+	}
+	return fmt.Sprintf("SliceNilPolicy(%d)", byte(p))
+}
+
 func WithNilChance(f float32) Option {
 	return func(cf *ConsumeFuzzer) {
 		cf.nilChance = f
 	}
 }
 
+// WithDepthScaledNilChance sets a nil chance that grows with the recursion
+// depth, from base at the top level to 1 at the maximum depth, so recursive
+// types such as lists and trees terminate naturally.
+func WithDepthScaledNilChance(base float32) Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.nilChance = base
+		cf.depthScaledNilChance = true
+	}
+}
+
+// WithDepthScaledCollections halves the maximum number of slice and map
+// elements at each level of nesting, so that nested collections such as
+// map[string]map[string][]int stay small. Byte slices are not affected.
+func WithDepthScaledCollections() Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.depthScaledCollections = true
+	}
+}
+
+// WithMaxDepth sets the maximum nesting depth of generated values.
+// NewConsumer panics if i is not positive.
 func WithMaxDepth(i int64) Option {
 	return func(cf *ConsumeFuzzer) {
 		cf.maxDepth = i
 	}
 }
 
+// WithMinSliceElements sets the minimum number of elements of generated
+// slices, 0 by default. Slices have between the minimum, inclusive, and the
+// maximum set with WithMaxSliceElements, exclusive. NewConsumer panics if
+// the minimum is not less than the maximum.
+func WithMinSliceElements(n uint32) Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.minSliceElements = n
+	}
+}
+
+// WithMaxSliceElements sets the exclusive maximum number of elements of
+// generated slices, 50 by default. See WithMinSliceElements.
+func WithMaxSliceElements(n uint32) Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.maxSliceElements = n
+	}
+}
+
+// WithMaxByteSliceLen sets the maximum length of generated byte slices,
+// which default to 10000000. Byte slices are also never longer than the
+// number of bytes left in the input.
+func WithMaxByteSliceLen(n uint32) Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.maxByteSliceLen = n
+	}
+}
+
+// WithMaxStringLen sets the maximum length of strings and byte slices read
+// from the input, 2000000 by default. Longer lengths are clamped to it, see
+// bytesource.ByteSource.GetLengthPrefixed. It does not apply to a
+// WithSource source.
+func WithMaxStringLen(n uint32) Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.maxStringLen = n
+	}
+}
+
+// WithMaxTotalBytes limits the total number of slice, map and string
+// elements allocated by a single generation. Once exceeded, generation
+// aborts with ErrMaxTotalBytesExceeded. A value <= 0 disables the limit.
+func WithMaxTotalBytes(n int64) Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.maxTotalBytes = n
+	}
+}
+
+// WithMaxMapKeyAttempts sets how many times a map key is generated when it
+// collides with an existing key before giving up, so that maps with
+// low-entropy keys get close to the requested size. It defaults to 4, 1
+// disables the retries. NewConsumer panics if n is less than 1.
+func WithMaxMapKeyAttempts(n int) Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.maxMapKeyAttempts = n
+	}
+}
+
+// WithBoundaryCollectionSizes makes half of the generated slices and maps
+// have a boundary size such as 0, 1, or a power of two plus or minus one.
+func WithBoundaryCollectionSizes() Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.boundaryCollectionSizes = true
+	}
+}
+
+// WithSource makes the consumer decode values from s instead of the input
+// passed to NewConsumer, e.g. a reader from bytesource.NewFromReader. The
+// source is used as is, options configuring the input source do not apply.
+func WithSource(s bytesource.Source) Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.source = s
+	}
+}
+
+// WithControlSource takes the structural decisions from s: whether values
+// are nil, the lengths of collections, the field order and which
+// implementation, interesting value or reused pointer is picked. The input
+// then only holds the contents of the values, so mutating it changes field
+// contents without changing the shape of the generated value.
+func WithControlSource(s bytesource.Source) Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.control = s
+	}
+}
+
+// WithEndianness sets the byte order of multi-byte values, little endian by
+// default. With a nil order each value consumes an extra byte picking its
+// byte order, as in earlier versions. It does not apply to a WithSource
+// source.
+func WithEndianness(order binary.ByteOrder) Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.byteOrder = order
+	}
+}
+
+// WithFallbackRandom makes generation continue once the input is exhausted,
+// reading further bytes from a math/rand stream seeded with seed. The input
+// bytes are consumed first, so a given input and seed always generate the
+// same value. It does not apply to a WithSource source.
+func WithFallbackRandom(seed int64) Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.fallbackRandom = true
+		cf.fallbackSeed = seed
+	}
+}
+
+// WithFillZeroOnly only generates values that are currently zero, leaving
+// already set values untouched. Structs, arrays and non-nil pointers are
+// descended into so their zero fields are filled too. A value deliberately
+// set to its zero value cannot be told apart from an unset one and is
+// overwritten.
+func WithFillZeroOnly() Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.fillZeroOnly = true
+	}
+}
+
+// WithPreserveNonZeroFields leaves struct fields that are already non-zero
+// untouched, so that some inputs can be pinned while the others are
+// generated. Unlike WithFillZeroOnly, a non-zero field is kept as a whole,
+// its zero subfields are not filled. A field deliberately set to its zero
+// value cannot be told apart from an unset one and is generated.
+func WithPreserveNonZeroFields() Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.preserveNonZeroFields = true
+	}
+}
+
+// WithPartialOnExhaustion makes GenerateStruct return successfully when the
+// input runs out, leaving the values not generated yet untouched.
+func WithPartialOnExhaustion() Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.partialOnExhaustion = true
+	}
+}
+
 func WithUnexportedFieldStrategy(s HandlingStrategy) Option {
 	return func(cf *ConsumeFuzzer) {
 		cf.unexportedFieldStrategy = s
 	}
 }
 
+// WithUnexportedAllowedPackages restricts the KeepFuzzing unexported field
+// strategy to the fields declared in the packages with the given import
+// paths. Unexported fields of other packages are left untouched, as with
+// IgnoreValue, so that the invariants of foreign types are preserved.
+func WithUnexportedAllowedPackages(paths ...string) Option {
+	return func(cf *ConsumeFuzzer) {
+		if cf.unexportedPackages == nil {
+			cf.unexportedPackages = make(map[string]bool)
+		}
+		for _, p := range paths {
+			cf.unexportedPackages[p] = true
+		}
+	}
+}
+
 func WithUnknownTypeStrategy(s HandlingStrategy) Option {
 	return func(cf *ConsumeFuzzer) {
 		cf.unknownTypeStrategy = s
 	}
 }
 
+// WithStubFuncFields generates func values as functions doing nothing and
+// returning zero values, instead of leaving them nil.
+func WithStubFuncFields() Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.stubFuncFields = true
+	}
+}
+
+// WithChannelSupport generates channels, with a buffer of up to 16 elements
+// partly filled with generated elements, instead of leaving them nil.
+func WithChannelSupport() Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.channelSupport = true
+	}
+}
+
+// WithAsciiStrings generates strings made only of printable ASCII
+// characters, from 0x20 to 0x7E, which keeps crash reports readable. Each
+// character consumes one byte of input. Strings set by fuzz tags, custom
+// functions and string corpora are not affected.
+func WithAsciiStrings() Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.asciiStrings = true
+	}
+}
+
+// WithFiniteFloats maps the NaN and infinite floats decoded from the input
+// to finite values.
+func WithFiniteFloats() Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.finiteFloats = true
+	}
+}
+
+// WithShuffledFields generates struct fields in an order read from the
+// input instead of their declaration order, so that mutating the input
+// moves bytes between fields.
+func WithShuffledFields() Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.shuffledFields = true
+	}
+}
+
+// WithPointerAliasing lets the input point a pointer at a value of the
+// same type allocated earlier in the same generation instead of allocating
+// a new one. This creates shared and cyclic graphs, which stress code such
+// as deep equality checks and serializers. Code walking the generated
+// values must therefore handle cycles.
+func WithPointerAliasing() Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.pointerAliasing = true
+	}
+}
+
+// WithSliceNilPolicy sets whether slices without elements are generated as
+// nil or empty slices, which matters to code such as encoding/json that
+// tells them apart. The input is read the same way for every policy.
+func WithSliceNilPolicy(p SliceNilPolicy) Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.sliceNilPolicy = p
+	}
+}
+
+// WithBlockedTypes prevents values of the given types from being generated.
+// They are left untouched, or fail the generation if s is FailWithError.
+// Types are matched exactly, so blocking T does not block *T.
+func WithBlockedTypes(s HandlingStrategy, types ...reflect.Type) Option {
+	return func(cf *ConsumeFuzzer) {
+		for _, t := range types {
+			cf.blockedTypes[t] = s
+		}
+	}
+}
+
+// WithBigNumberSupport generates valid math/big Int and Float values
+// instead of filling their unexported fields. Custom functions registered
+// for these types and kind functions take precedence.
+func WithBigNumberSupport() Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.addBuiltinFuncs(bigNumberFuncs)
+	}
+}
+
+// WithDepthExceededStrategy sets how values nested deeper than the maximum
+// depth are handled. They are left untouched by default, or fail the
+// generation with FailWithError.
+func WithDepthExceededStrategy(s HandlingStrategy) Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.depthExceededStrategy = s
+	}
+}
+
+// WithNetworkTypeSupport generates valid url.URL and net.IP values instead
+// of filling their fields. Custom functions registered for these types and
+// kind functions take precedence.
+func WithNetworkTypeSupport() Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.addBuiltinFuncs(networkFuncs)
+	}
+}
+
+// WithHTTPTypeSupport generates http.Header and url.Values with realistic
+// names and values instead of random keys. Header names are canonical and
+// every name has at least one value. Custom functions registered for these
+// types and kind functions take precedence.
+func WithHTTPTypeSupport() Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.addBuiltinFuncs(httpFuncs)
+	}
+}
+
 func WithoutCustomFuncs() Option {
 	return func(cf *ConsumeFuzzer) {
 		cf.disallowCustomFuncs = true
 	}
 }
 
+// WithKindFunction registers a function generating every value of the given
+// kind. Handlers registered for an exact type and interface implementations
+// take precedence, see Generate.
+func WithKindFunction(k reflect.Kind, f func(c Continue) (reflect.Value, error)) Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.kindFuncs[k] = f
+	}
+}
+
+// WithTypeReplacement makes values of type from be generated by construct,
+// e.g. to generate an io.Reader field as a *bytes.Reader. The constructed
+// value must be assignable or convertible to from, an invalid value sets
+// the zero value.
+func WithTypeReplacement(from reflect.Type, construct func(c Continue) (reflect.Value, error)) Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.typeReplacements[from] = construct
+	}
+}
+
+// WithEnumValues restricts the values generated for t to values. Each value
+// must be convertible to t.
+func WithEnumValues(t reflect.Type, values []interface{}) Option {
+	converted := convertValues(t, values, "enum")
+	return func(cf *ConsumeFuzzer) {
+		cf.enumValues[t] = converted
+	}
+}
+
+// WithInterestingValues makes a quarter of the values generated for t be
+// picked from values, e.g. 0, -1 or math.MaxInt64, the others being fuzzed
+// as usual. Each value must be convertible to t.
+func WithInterestingValues(t reflect.Type, values []interface{}) Option {
+	converted := convertValues(t, values, "interesting")
+	return func(cf *ConsumeFuzzer) {
+		cf.interestingValues[t] = converted
+	}
+}
+
+// convertValues converts values to t. It panics if values is empty or if a
+// value is not convertible.
+func convertValues(t reflect.Type, values []interface{}, what string) []reflect.Value {
+	if len(values) == 0 {
+		panic(fmt.Sprintf("no %s values for %s", what, t))
+	}
+	converted := make([]reflect.Value, len(values))
+	for i, value := range values {
+		v := reflect.ValueOf(value)
+		if !v.IsValid() || !v.Type().ConvertibleTo(t) {
+			panic(fmt.Sprintf("%s value %v is not convertible to %s", what, value, t))
+		}
+		converted[i] = v.Convert(t)
+	}
+	return converted
+}
+
+// WithErrorValues makes error fields and values be generated by picking
+// nil or one of errs.
+func WithErrorValues(errs ...error) Option {
+	values := []reflect.Value{reflect.Zero(errorType)}
+	for _, err := range errs {
+		if err == nil {
+			panic("nil error value, nil is always a choice")
+		}
+		values = append(values, reflect.ValueOf(err))
+	}
+	return func(cf *ConsumeFuzzer) {
+		cf.enumValues[errorType] = values
+	}
+}
+
+var stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+
+// WithStringCorpus generates values of t, which must implement fmt.Stringer,
+// by picking a string from corpus and parsing it back, with UnmarshalText
+// if t implements encoding.TextUnmarshaler or according to its kind
+// otherwise.
+func WithStringCorpus(t reflect.Type, corpus []string) Option {
+	if !t.Implements(stringerType) && !reflect.PtrTo(t).Implements(stringerType) {
+		panic(fmt.Sprintf("%s does not implement fmt.Stringer", t))
+	}
+	if len(corpus) == 0 {
+		panic(fmt.Sprintf("empty string corpus for %s", t))
+	}
+	return func(cf *ConsumeFuzzer) {
+		cf.stringCorpora[t] = corpus
+	}
+}
+
+// WithFieldHook registers a function called after each struct field is
+// generated, with the path of the field, e.g. Foo.Bar[3].Baz, and its value.
+func WithFieldHook(hook func(path string, v reflect.Value)) Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.fieldHook = hook
+	}
+}
+
+// WithLogger registers a function called with diagnostics at key decisions
+// of the generation: values left nil, the maximum depth being exceeded, the
+// source being exhausted and custom functions being called. Each message
+// starts with the path of the value, e.g. Foo.Bar[3]: left nil. Nothing is
+// logged when no logger is set.
+func WithLogger(logger func(format string, args ...interface{})) Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.logger = logger
+	}
+}
+
+// WithCustomFunction registers a custom function of the form
+// func(*T, Continue) error, or func(M, Continue) error for a map type M.
+// Custom functions are matched on the exact type only: a function for T is
+// not used for types embedding T, nor for named types whose underlying type
+// is T. Those are generated field by field, and embedded fields of type T
+// use the function as any other field would.
 func WithCustomFunction(f any) Option {
 	return func(cf *ConsumeFuzzer) {
 		cf.addFuncs([]any{f})
 	}
 }
+
+// WithCustomFuncInheritance makes structs without a custom function of their
+// own use the custom functions of their embedded types, even when the
+// embedded type is unexported and would otherwise be skipped or, for
+// structs, generated field by field.
+func WithCustomFuncInheritance() Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.customFuncInheritance = true
+	}
+}
+
+// WithCustomFunctions registers several custom functions at once, each as
+// WithCustomFunction does. NewConsumer panics with a description of the
+// offending function if one of them is not of the form
+// func(*T, Continue) error or func(M, Continue) error.
+func WithCustomFunctions(funcs ...any) Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.addFuncs(funcs)
+	}
+}
+
+// WithInterfaceImplementations registers the concrete types used to generate
+// values of the interface type iface. Each value, e.g. each element of a
+// slice of iface, picks its implementation independently.
+func WithInterfaceImplementations(iface reflect.Type, impls ...reflect.Type) Option {
+	return func(cf *ConsumeFuzzer) {
+		cf.addImplementations(iface, impls)
+	}
+}
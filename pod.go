@@ -0,0 +1,139 @@
+// Copyright 2023 The go-fuzz-headers Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gofuzzheaders
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+
+	"github.com/kruskall/go-fuzz-headers/bytesource"
+)
+
+// podLayout describes a struct made only of exported, untagged,
+// fixed-width fields: where each field is decoded from in the contiguous
+// block of size bytes that the fields consume one after the other.
+type podLayout struct {
+	fields []podField
+	size   int
+}
+
+type podField struct {
+	index  int
+	kind   reflect.Kind
+	offset int
+}
+
+// podWidth returns the number of bytes fuzzPrimitive reads for a value of
+// kind k, or 0 if k is not fixed-width.
+func podWidth(k reflect.Kind) int {
+	switch k {
+	case reflect.Bool, reflect.Uint8, reflect.Uint64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return 1
+	case reflect.Uint16:
+		return 2
+	case reflect.Uint32, reflect.Float32:
+		return 4
+	case reflect.Float64:
+		return 8
+	}
+	return 0
+}
+
+// podLayoutOf returns the layout of t, or nil if t is not a POD struct:
+// one of its fields is unexported, tagged, not fixed-width, blocked or has
+// a handler. The result is cached per type.
+func (f *ConsumeFuzzer) podLayoutOf(t reflect.Type) *podLayout {
+	if l, ok := f.podLayouts[t]; ok {
+		return l
+	}
+
+	l := &podLayout{}
+	for i := 0; i < t.NumField() && l != nil; i++ {
+		sf := t.Field(i)
+		width := podWidth(sf.Type.Kind())
+		if !sf.IsExported() || sf.Tag.Get("fuzz") != "" || width == 0 ||
+			f.hasHandlerForType(sf.Type) || f.isBlocked(sf.Type) {
+			l = nil
+			break
+		}
+		l.fields = append(l.fields, podField{index: i, kind: sf.Type.Kind(), offset: l.size})
+		l.size += width
+	}
+	if l != nil && len(l.fields) == 0 {
+		l = nil
+	}
+	f.podLayouts[t] = l
+	return l
+}
+
+// fuzzPOD fills the POD struct e from a single contiguous block of the
+// input, decoded into the same values as fuzzFields would. It reports
+// false, without consuming anything, when e has to go through fuzzFields:
+// when it is not a POD struct, when fields are visited or recorded one by
+// one, or when the block is not available, so that a short input fails as
+// it does field by field.
+func (f *ConsumeFuzzer) fuzzPOD(e reflect.Value) bool {
+	if f.shuffledFields || f.fillZeroOnly || f.preserveNonZeroFields ||
+		f.fieldHook != nil || f.recordDecode || f.curDepth >= f.maxDepth {
+		return false
+	}
+	src, ok := f.source.(*bytesource.ByteSource)
+	if !ok || src.ByteOrder() == nil {
+		return false
+	}
+	l := f.podLayoutOf(e.Type())
+	if l == nil {
+		return false
+	}
+	b, err := src.GetExactBytes(l.size)
+	if err != nil {
+		return false
+	}
+	l.decode(e, b, src.ByteOrder(), f.finiteFloats)
+	return true
+}
+
+// decode sets the fields of e from b as fuzzPrimitive does.
+func (l *podLayout) decode(e reflect.Value, b []byte, order binary.ByteOrder, finite bool) {
+	for _, pf := range l.fields {
+		v, p := e.Field(pf.index), b[pf.offset:]
+		switch pf.kind {
+		case reflect.Bool:
+			v.SetBool(p[0]%2 == 0)
+		case reflect.Uint8, reflect.Uint64:
+			v.SetUint(uint64(p[0]))
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			v.SetInt(int64(p[0]))
+		case reflect.Uint16:
+			v.SetUint(uint64(order.Uint16(p)))
+		case reflect.Uint32:
+			v.SetUint(uint64(order.Uint32(p)))
+		case reflect.Float32:
+			x := math.Float32frombits(order.Uint32(p))
+			if finite {
+				x = bytesource.Finite32(x)
+			}
+			v.SetFloat(float64(x))
+		case reflect.Float64:
+			x := math.Float64frombits(order.Uint64(p))
+			if finite {
+				x = bytesource.Finite64(x)
+			}
+			v.SetFloat(x)
+		}
+	}
+}
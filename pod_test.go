@@ -0,0 +1,131 @@
+// Copyright 2023 The go-fuzz-headers Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gofuzzheaders
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+type podStruct struct {
+	A, B, C, D, E, F, G, H, I, J int
+	I8                           int8
+	U16                          uint16
+	U32                          uint32
+	U64                          uint64
+	F32                          float32
+	F64                          float64
+	Bool                         bool
+	Byte                         byte
+}
+
+func TestPODMatchesReflectionPath(t *testing.T) {
+	options := map[string][]Option{
+		"default":     nil,
+		"big endian":  {WithEndianness(binary.BigEndian)},
+		"input order": {WithEndianness(nil)},
+		"finite":      {WithFiniteFloats()},
+		"fallback":    {WithFallbackRandom(1)},
+	}
+	r := rand.New(rand.NewSource(1))
+	for name, opts := range options {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				input := make([]byte, r.Intn(80))
+				r.Read(input)
+
+				var fast, slow struct {
+					P podStruct
+					S []podStruct
+				}
+
+				fastConsumer := NewConsumer(input, opts...)
+				fastErr := fastConsumer.GenerateStruct(&fast)
+
+				slowConsumer := NewConsumer(input, opts...)
+				slowConsumer.podLayouts[reflect.TypeOf(podStruct{})] = nil
+				slowErr := slowConsumer.GenerateStruct(&slow)
+
+				if fmt.Sprint(fastErr) != fmt.Sprint(slowErr) {
+					t.Errorf("errors differ: fast %v, slow %v", fastErr, slowErr)
+				}
+				// Compare the printed values as NaN floats are never
+				// DeepEqual.
+				if fmt.Sprint(fast) != fmt.Sprint(slow) {
+					t.Errorf("values differ:\nfast %+v\nslow %+v", fast, slow)
+				}
+				if fastConsumer.source.Position() != slowConsumer.source.Position() {
+					t.Errorf("fast path consumed %d bytes, slow path %d", fastConsumer.source.Position(), slowConsumer.source.Position())
+				}
+			}
+		})
+	}
+}
+
+func TestPODDetection(t *testing.T) {
+	f := NewConsumer(nil, WithEnumValues(reflect.TypeOf(podEnum(0)), []interface{}{1}))
+	tests := []struct {
+		v    any
+		want bool
+	}{
+		{podStruct{}, true},
+		{struct{ S string }{}, false},
+		{struct{ a int }{}, false},
+		{struct {
+			A int `fuzz:"oneof=1"`
+		}{}, false},
+		{struct{ E podEnum }{}, false},
+		{struct{ U uint }{}, false},
+		{struct{}{}, false},
+	}
+	for _, tt := range tests {
+		if got := f.podLayoutOf(reflect.TypeOf(tt.v)) != nil; got != tt.want {
+			t.Errorf("podLayoutOf(%T) != nil = %v, want %v", tt.v, got, tt.want)
+		}
+	}
+
+	if size := f.podLayoutOf(reflect.TypeOf(podStruct{})).size; size != 32 {
+		t.Errorf("got a block of %d bytes, want 32", size)
+	}
+}
+
+type podEnum int
+
+func BenchmarkGenerateStructPOD(b *testing.B) {
+	type tenInts struct {
+		A, B, C, D, E, F, G, H, I, J int
+	}
+	input := make([]byte, 64*10)
+	for _, pod := range []bool{true, false} {
+		name := "reflection"
+		if pod {
+			name = "pod"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var s [64]tenInts
+				f := NewConsumer(input)
+				if !pod {
+					f.podLayouts[reflect.TypeOf(tenInts{})] = nil
+				}
+				_ = f.GenerateStruct(&s)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2023 The go-fuzz-headers Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gofuzzheaders
+
+import (
+	"errors"
+	"reflect"
+)
+
+// DecodeStep records how a range of the input was decoded into a value.
+// Struct and array values are not recorded themselves, only their
+// elements are.
+type DecodeStep struct {
+	Path   string
+	Offset uint32
+	Size   uint32
+	Value  interface{}
+}
+
+// Replay generates a value of the same type as sample from data, as
+// GenerateStruct would, and returns it together with the decode log. It is
+// meant for understanding how a crashing input maps onto a value. A nil
+// sample has no type and is an error.
+func Replay(data []byte, sample interface{}, opts ...Option) (interface{}, []DecodeStep, error) {
+	if sample == nil {
+		return nil, nil, errors.New("sample is nil, expected a value of the type to generate")
+	}
+	f := NewConsumer(data, opts...)
+	f.recordDecode = true
+	v := reflect.New(reflect.TypeOf(sample))
+	err := f.GenerateStruct(v.Interface())
+	return v.Elem().Interface(), f.decodeLog, err
+}
+
+// recordStep appends e, generated from the bytes starting at start, to
+// the decode log.
+func (f *ConsumeFuzzer) recordStep(start uint32, e reflect.Value) {
+	if k := e.Kind(); k == reflect.Struct || k == reflect.Array {
+		return
+	}
+	f.decodeLog = append(f.decodeLog, DecodeStep{
+		Path:   f.pathString(),
+		Offset: start,
+		Size:   f.source.Position() - start,
+		Value:  e.Interface(),
+	})
+}
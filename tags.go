@@ -0,0 +1,525 @@
+// Copyright 2023 The go-fuzz-headers Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gofuzzheaders
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kruskall/go-fuzz-headers/bytesource"
+)
+
+// fieldTag holds the options of a `fuzz:"..."` struct tag. Options are
+// comma separated and are either a flag, e.g. `fuzz:"monotonic"`, or a
+// key/value pair, e.g. `fuzz:"key=value"`. The value of a list option,
+// e.g. `fuzz:"crc32=over:Header,Body"`, extends to the end of the tag.
+type fieldTag map[string]string
+
+// listOptions are the options whose value is a comma separated list.
+var listOptions = map[string]bool{"crc32": true}
+
+func parseFieldTag(tag string) fieldTag {
+	if tag == "" {
+		return nil
+	}
+	ft := make(fieldTag)
+	for tag != "" {
+		var opt string
+		opt, tag, _ = strings.Cut(tag, ",")
+		key, value, _ := strings.Cut(opt, "=")
+		key = strings.TrimSpace(key)
+		if listOptions[key] && tag != "" {
+			value += "," + tag
+			tag = ""
+		}
+		ft[key] = value
+	}
+	return ft
+}
+
+func (t fieldTag) has(key string) bool {
+	_, ok := t[key]
+	return ok
+}
+
+var httpMethods = []string{"GET", "POST", "PUT", "DELETE", "PATCH", "HEAD", "OPTIONS"}
+
+// scripts maps the names accepted by the script tag to their rune ranges.
+var scripts = map[string][][2]rune{
+	"latin":    {{'A', 'Z'}, {'a', 'z'}, {0xC0, 0xFF}},
+	"cyrillic": {{0x0400, 0x04FF}},
+	"arabic":   {{0x0600, 0x06FF}},
+	"cjk":      {{0x4E00, 0x9FFF}},
+	"emoji":    {{0x1F300, 0x1F5FF}, {0x1F600, 0x1F64F}},
+}
+
+// stringFormats maps the names accepted by the format tag to their
+// generators.
+var stringFormats = map[string]func(Continue) (string, error){
+	"email":    Continue.GetEmail,
+	"hostname": Continue.GetHostname,
+	"uuid":     Continue.GetUUID,
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// fuzzField generates the value of a struct field, honouring its fuzz tag.
+func (f *ConsumeFuzzer) fuzzField(e reflect.Value, tag fieldTag) (err error) {
+	if len(tag) == 0 || !e.CanSet() {
+		return f.fuzzStruct(e)
+	}
+	if f.fillZeroOnly && !e.IsZero() {
+		return nil
+	}
+
+	handled, start := true, f.source.Position()
+	defer func() {
+		if err != nil {
+			err = f.generateError(err)
+		} else if handled && f.recordDecode {
+			f.recordStep(start, e)
+		}
+	}()
+
+	switch {
+	case tag.has("monotonic"):
+		return f.fuzzMonotonicTimes(e)
+	case tag.has("httpmethod"):
+		return f.fuzzStringChoice(e, httpMethods)
+	case tag.has("importpath"):
+		return f.fuzzStringFunc(e, f.continuation().GetImportPath)
+	case tag.has("jsonbytes"):
+		if e.Kind() != reflect.Slice || e.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("jsonbytes tag requires a []byte field, got %s", e.Type())
+		}
+		b, err := f.continuation().GetJSON()
+		if err != nil {
+			return err
+		}
+		e.SetBytes(b)
+		return nil
+	case tag.has("oneof"):
+		var invalidRate float64
+		if rate, ok := tag["invalidrate"]; ok {
+			invalidRate, err = strconv.ParseFloat(rate, 64)
+			if err != nil {
+				return fmt.Errorf("invalid invalidrate: %w", err)
+			}
+		}
+		return f.fuzzOneOf(e, oneOfChoices(tag["oneof"]), invalidRate)
+	case tag.has("crc32"):
+		// The checksum is computed by checksumFields once the struct is
+		// generated.
+		if e.Kind() != reflect.Uint32 {
+			return fmt.Errorf("crc32 tag requires a uint32 field, got %s", e.Type())
+		}
+		return nil
+	case tag.has("langtag"):
+		return f.fuzzStringFunc(e, f.continuation().GetLanguageTag)
+	case tag.has("format"):
+		gen, ok := stringFormats[tag["format"]]
+		if !ok {
+			return fmt.Errorf("unknown format: %q", tag["format"])
+		}
+		return f.fuzzStringFunc(e, func() (string, error) {
+			return gen(f.continuation())
+		})
+	case tag.has("minlen") || tag.has("maxlen"):
+		return f.fuzzBoundedLen(e, tag)
+	case tag.has("script"):
+		ranges, ok := scripts[tag["script"]]
+		if !ok {
+			return fmt.Errorf("unknown script: %q", tag["script"])
+		}
+		return f.fuzzStringFunc(e, func() (string, error) {
+			return f.scriptString(ranges)
+		})
+	}
+	handled = false
+	return f.fuzzStruct(e)
+}
+
+// lengthBounds returns the length window set by the minlen and maxlen
+// options of tag, both inclusive. Without maxlen, the window holds
+// maxElements lengths from minlen on, so that it excludes minlen plus
+// maxElements like the maximum of an untagged slice.
+func lengthBounds(tag fieldTag, maxElements int) (int, int, error) {
+	bounds := []int{0, -1}
+	for i, key := range []string{"minlen", "maxlen"} {
+		value, ok := tag[key]
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("invalid %s %q, expected a non-negative integer", key, value)
+		}
+		bounds[i] = n
+	}
+	if bounds[1] < 0 {
+		bounds[1] = bounds[0]
+		if maxElements > 0 {
+			bounds[1] += maxElements - 1
+		}
+	}
+	if bounds[0] > bounds[1] {
+		return 0, 0, fmt.Errorf("minlen %d greater than maxlen %d", bounds[0], bounds[1])
+	}
+	return bounds[0], bounds[1], nil
+}
+
+// fuzzBoundedLen sets a string or slice field to a value whose length is
+// within the bounds of its minlen and maxlen options. Bounded slices are
+// never nil unless they are empty and the slice nil policy asks for it.
+func (f *ConsumeFuzzer) fuzzBoundedLen(e reflect.Value, tag fieldTag) error {
+	if e.Kind() != reflect.String && e.Kind() != reflect.Slice {
+		return fmt.Errorf("minlen and maxlen tags require a string or slice field, got %s", e.Type())
+	}
+	min, max, err := lengthBounds(tag, int(f.maxSliceElements))
+	if err != nil {
+		return err
+	}
+	// Never allocate more characters or elements than there are bytes
+	// left, so that a large maxlen can't make a short input allocate
+	// without limit, even for elements that consume no input.
+	if uint64(max) > uint64(f.source.Remaining()) {
+		max = int(f.source.Remaining())
+		if max < min {
+			return fmt.Errorf("minlen %d greater than the %d bytes left: %w", min, max, bytesource.ErrNotEnoughBytes)
+		}
+	}
+	n, err := f.controlSource().GetIntInRange(min, max)
+	if err != nil {
+		return err
+	}
+	if err := f.allocate(n); err != nil {
+		return err
+	}
+
+	if e.Kind() == reflect.String {
+		if f.asciiStrings {
+			str, err := f.source.GetStringFrom(printableASCII, n)
+			if err != nil {
+				return err
+			}
+			e.SetString(str)
+			return nil
+		}
+		b, err := f.source.GetExactBytes(n)
+		if err != nil {
+			return err
+		}
+		e.SetString(string(b))
+		return nil
+	}
+
+	if n == 0 && f.sliceNilPolicy == SliceNilWhenEmpty {
+		e.Set(reflect.Zero(e.Type()))
+		return nil
+	}
+	slice := reflect.MakeSlice(e.Type(), n, n)
+	for i := 0; i < n; i++ {
+		f.pushIndex(i)
+		err := f.fuzzStruct(slice.Index(i))
+		f.popPath()
+		if err != nil {
+			return err
+		}
+	}
+	e.Set(slice)
+	return nil
+}
+
+// checksumFields sets the crc32 tagged fields of the struct e to the CRC32
+// of the fields they cover, in order. Strings and byte slices contribute
+// their bytes, fixed size values their big endian encoding.
+func (f *ConsumeFuzzer) checksumFields(e reflect.Value, fields []int) error {
+	for _, i := range fields {
+		sf := e.Type().Field(i)
+		f.pushPath(sf.Name)
+		err := f.checksumField(e, e.Field(i), parseFieldTag(sf.Tag.Get("fuzz"))["crc32"])
+		if err != nil {
+			err = f.generateError(err)
+		}
+		f.popPath()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *ConsumeFuzzer) checksumField(e, field reflect.Value, spec string) error {
+	over := strings.TrimPrefix(spec, "over:")
+	if over == spec || over == "" {
+		return fmt.Errorf("invalid crc32 tag %q, expected over:Field,...", spec)
+	}
+	var buf bytes.Buffer
+	for _, name := range strings.Split(over, ",") {
+		v := e.FieldByName(strings.TrimSpace(name))
+		if !v.IsValid() {
+			return fmt.Errorf("crc32 tag refers to unknown field %q", name)
+		}
+		if err := writeFieldBytes(&buf, v); err != nil {
+			return err
+		}
+	}
+	if field.CanSet() {
+		field.SetUint(uint64(crc32.ChecksumIEEE(buf.Bytes())))
+	}
+	return nil
+}
+
+// writeFieldBytes writes the serialized value of v to buf.
+func writeFieldBytes(buf *bytes.Buffer, v reflect.Value) error {
+	switch {
+	case v.Kind() == reflect.String:
+		buf.WriteString(v.String())
+		return nil
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		buf.Write(v.Bytes())
+		return nil
+	case !v.CanInterface():
+		return fmt.Errorf("cannot checksum unexported field of type %s", v.Type())
+	}
+	if err := binary.Write(buf, binary.BigEndian, v.Interface()); err != nil {
+		return fmt.Errorf("cannot checksum a %s field: %w", v.Type(), err)
+	}
+	return nil
+}
+
+// fuzzMonotonicTimes fills a []time.Time with non-decreasing timestamps:
+// a base time followed by cumulative fuzzed deltas.
+func (f *ConsumeFuzzer) fuzzMonotonicTimes(e reflect.Value) error {
+	if e.Kind() != reflect.Slice || e.Type().Elem() != timeType {
+		return fmt.Errorf("monotonic tag requires a []time.Time field, got %s", e.Type())
+	}
+
+	isNil, err := f.shouldBeNil(false)
+	if err != nil {
+		return err
+	}
+	if isNil {
+		return nil
+	}
+
+	numOfElements, err := f.sliceLen(e.Type())
+	if err != nil {
+		return err
+	}
+	if err := f.allocate(numOfElements); err != nil {
+		return err
+	}
+
+	t, err := f.source.GetTime()
+	if err != nil {
+		return err
+	}
+
+	times := reflect.MakeSlice(e.Type(), numOfElements, numOfElements)
+	for i := 0; i < numOfElements; i++ {
+		delta, err := f.source.GetUint16()
+		if err != nil {
+			return err
+		}
+		t = t.Add(time.Duration(delta) * time.Millisecond)
+		times.Index(i).Set(reflect.ValueOf(t))
+	}
+	e.Set(times)
+	return nil
+}
+
+// fuzzStringChoice sets a string field to one of choices.
+func (f *ConsumeFuzzer) fuzzStringChoice(e reflect.Value, choices []string) error {
+	if e.Kind() != reflect.String {
+		return fmt.Errorf("string tag used on a %s field", e.Type())
+	}
+	i, err := f.source.GetChoiceIndex(len(choices))
+	if err != nil {
+		return err
+	}
+	e.SetString(choices[i])
+	return nil
+}
+
+// fuzzStringFunc sets a string field to the value returned by gen.
+func (f *ConsumeFuzzer) fuzzStringFunc(e reflect.Value, gen func() (string, error)) error {
+	if e.Kind() != reflect.String {
+		return fmt.Errorf("string tag used on a %s field", e.Type())
+	}
+	str, err := gen()
+	if err != nil {
+		return err
+	}
+	e.SetString(str)
+	return nil
+}
+
+// scriptString returns a string of up to 31 runes taken from ranges.
+func (f *ConsumeFuzzer) scriptString(ranges [][2]rune) (string, error) {
+	var size int
+	for _, r := range ranges {
+		size += int(r[1]-r[0]) + 1
+	}
+
+	length, err := f.source.GetPositiveInt()
+	if err != nil {
+		return "", err
+	}
+	runes := make([]rune, 0, length%32)
+	for i := 0; i < cap(runes); i++ {
+		n, err := f.source.GetUint16()
+		if err != nil {
+			return "", err
+		}
+		idx := rune(int(n) % size)
+		for _, r := range ranges {
+			if idx <= r[1]-r[0] {
+				runes = append(runes, r[0]+idx)
+				break
+			}
+			idx -= r[1] - r[0] + 1
+		}
+	}
+	return string(runes), nil
+}
+
+// oneOfChoices splits the value of a oneof tag, separated by "|" or, if it
+// has none, by spaces, e.g. `fuzz:"oneof=GET POST PUT"`.
+func oneOfChoices(value string) []string {
+	if strings.Contains(value, "|") {
+		return strings.Split(value, "|")
+	}
+	return strings.Fields(value)
+}
+
+// fuzzOneOf sets e to one of choices. With probability invalidRate it is set
+// to a value that is not part of choices instead.
+func (f *ConsumeFuzzer) fuzzOneOf(e reflect.Value, choices []string, invalidRate float64) error {
+	if invalidRate > 0 {
+		b, err := f.source.GetByte()
+		if err != nil {
+			return err
+		}
+		if float64(b) < invalidRate*256 {
+			return f.fuzzNotOneOf(e, choices)
+		}
+	}
+
+	i, err := f.source.GetChoiceIndex(len(choices))
+	if err != nil {
+		return err
+	}
+	return setFromString(e, choices[i])
+}
+
+// fuzzNotOneOf sets e to a fuzzed value that is not part of choices.
+func (f *ConsumeFuzzer) fuzzNotOneOf(e reflect.Value, choices []string) error {
+	switch e.Kind() {
+	case reflect.String:
+		str, err := f.source.GetString()
+		if err != nil {
+			return err
+		}
+		e.SetString(str)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if err := f.fuzzPrimitive(e); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("oneof tag used on a %s field", e.Type())
+	}
+
+	excluded, err := parseChoices(e.Type(), choices)
+	if err != nil {
+		return err
+	}
+	// Step past the choices until the value is not one of them. One of
+	// len(excluded)+1 successive values is not a choice, unless the choices
+	// cover every value of the type.
+	for steps := 0; excluded[e.Interface()]; steps++ {
+		if steps == len(excluded) {
+			return fmt.Errorf("oneof choices cover every value of %s", e.Type())
+		}
+		switch e.Kind() {
+		case reflect.String:
+			e.SetString(e.String() + "_")
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			e.SetUint(e.Uint() + 1)
+		default:
+			e.SetInt(e.Int() + 1)
+		}
+	}
+	return nil
+}
+
+// parseChoices parses choices into values of type t, so that they compare
+// equal to the generated values they represent, e.g. "01" to 1.
+func parseChoices(t reflect.Type, choices []string) (map[interface{}]bool, error) {
+	parsed := make(map[interface{}]bool, len(choices))
+	for _, choice := range choices {
+		v := reflect.New(t).Elem()
+		if err := setFromString(v, choice); err != nil {
+			return nil, err
+		}
+		parsed[v.Interface()] = true
+	}
+	return parsed, nil
+}
+
+// setFromText parses s into e with its UnmarshalText method if it has one,
+// and according to its kind otherwise.
+func setFromText(e reflect.Value, s string) error {
+	if e.CanAddr() {
+		if u, ok := e.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			if err := u.UnmarshalText([]byte(s)); err != nil {
+				return fmt.Errorf("invalid value %q for %s: %w", s, e.Type(), err)
+			}
+			return nil
+		}
+	}
+	return setFromString(e, s)
+}
+
+// setFromString parses s into e according to its kind.
+func setFromString(e reflect.Value, s string) error {
+	switch e.Kind() {
+	case reflect.String:
+		e.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, e.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid value %q for %s: %w", s, e.Type(), err)
+		}
+		e.SetInt(i)
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, e.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid value %q for %s: %w", s, e.Type(), err)
+		}
+		e.SetUint(u)
+	default:
+		return fmt.Errorf("cannot set a %s field from a tag value", e.Type())
+	}
+	return nil
+}
@@ -0,0 +1,462 @@
+// Copyright 2023 The go-fuzz-headers Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gofuzzheaders_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	gofuzzheaders "github.com/kruskall/go-fuzz-headers"
+	"github.com/kruskall/go-fuzz-headers/bytesource"
+)
+
+func TestMonotonicTimes(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		input := make([]byte, 1024)
+		r.Read(input)
+
+		c := gofuzzheaders.NewConsumer(input, gofuzzheaders.WithNilChance(0))
+		s := struct {
+			Events []time.Time `fuzz:"monotonic"`
+		}{}
+
+		generate(t, c, &s)
+
+		for j := 1; j < len(s.Events); j++ {
+			if s.Events[j].Before(s.Events[j-1]) {
+				t.Fatalf("events are not monotonic: %v before %v", s.Events[j], s.Events[j-1])
+			}
+		}
+	}
+}
+
+func TestHTTPMethod(t *testing.T) {
+	valid := map[string]bool{
+		"GET": true, "POST": true, "PUT": true, "DELETE": true,
+		"PATCH": true, "HEAD": true, "OPTIONS": true,
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 256; i++ {
+		c := gofuzzheaders.NewConsumer([]byte{byte(i)})
+		s := struct {
+			Method string `fuzz:"httpmethod"`
+		}{}
+
+		generate(t, c, &s)
+
+		if !valid[s.Method] {
+			t.Fatalf("invalid http method: %q", s.Method)
+		}
+		seen[s.Method] = true
+	}
+	if len(seen) != len(valid) {
+		t.Errorf("expected all methods to be generated, got %v", seen)
+	}
+}
+
+func TestImportPath(t *testing.T) {
+	re := regexp.MustCompile(`^[a-z0-9]+\.[a-z]+(/[a-z0-9]+){1,3}$`)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		input := make([]byte, 128)
+		r.Read(input)
+
+		c := gofuzzheaders.NewConsumer(input)
+		s := struct {
+			Path string `fuzz:"importpath"`
+		}{}
+
+		generate(t, c, &s)
+
+		if !re.MatchString(s.Path) {
+			t.Errorf("%q is not an import path", s.Path)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	const hostname = `[a-z0-9]+(\.[a-z0-9]+){0,2}\.[a-z]+`
+	email := regexp.MustCompile(`^[a-z0-9+_-]+@` + hostname + `$`)
+	host := regexp.MustCompile(`^` + hostname + `$`)
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	r := rand.New(rand.NewSource(1))
+	generated := 0
+	for i := 0; i < 50; i++ {
+		input := make([]byte, 256)
+		r.Read(input)
+
+		c := gofuzzheaders.NewConsumer(input)
+		s := struct {
+			Email string `fuzz:"format=email"`
+			Host  string `fuzz:"format=hostname"`
+			UUID  string `fuzz:"format=uuid"`
+		}{}
+
+		if !tryGenerate(t, c, &s) {
+			continue
+		}
+		generated++
+
+		if !email.MatchString(s.Email) {
+			t.Errorf("%q is not an email", s.Email)
+		}
+		if !host.MatchString(s.Host) {
+			t.Errorf("%q is not a hostname", s.Host)
+		}
+		if !uuid.MatchString(s.UUID) {
+			t.Errorf("%q is not a uuid", s.UUID)
+		}
+	}
+	if generated < 40 {
+		t.Errorf("only %d of 50 inputs generated formatted strings", generated)
+	}
+
+	c := gofuzzheaders.NewConsumer(make([]byte, 64))
+	s := struct {
+		S string `fuzz:"format=ipv7"`
+	}{}
+	if err := c.GenerateStruct(&s); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+}
+
+func TestLengthBounds(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	generated := 0
+	seen := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		input := make([]byte, 256)
+		r.Read(input)
+
+		c := gofuzzheaders.NewConsumer(input)
+		s := struct {
+			Name  string   `fuzz:"minlen=3,maxlen=20"`
+			Items []int    `fuzz:"minlen=2,maxlen=5"`
+			Tags  []string `fuzz:"maxlen=1"`
+		}{}
+
+		if !tryGenerate(t, c, &s) {
+			continue
+		}
+		generated++
+		seen[len(s.Name)] = true
+
+		if len(s.Name) < 3 || len(s.Name) > 20 {
+			t.Errorf("got name of length %d, want between 3 and 20", len(s.Name))
+		}
+		if len(s.Items) < 2 || len(s.Items) > 5 {
+			t.Errorf("got %d items, want between 2 and 5", len(s.Items))
+		}
+		if s.Tags == nil || len(s.Tags) > 1 {
+			t.Errorf("got tags %#v, want a non-nil slice of at most 1 element", s.Tags)
+		}
+	}
+	if generated < 90 || len(seen) < 10 {
+		t.Errorf("got %d values with %d distinct name lengths", generated, len(seen))
+	}
+}
+
+func TestLengthBoundsMinOnly(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	generated := 0
+	for i := 0; i < 200; i++ {
+		input := make([]byte, 256)
+		r.Read(input)
+
+		c := gofuzzheaders.NewConsumer(input, gofuzzheaders.WithMaxSliceElements(10))
+		s := struct {
+			S string `fuzz:"minlen=3"`
+			B []byte `fuzz:"minlen=3"`
+		}{}
+		if !tryGenerate(t, c, &s) {
+			continue
+		}
+		generated++
+
+		for name, n := range map[string]int{"string": len(s.S), "byte slice": len(s.B)} {
+			if n < 3 || n >= 13 {
+				t.Errorf("got %s of length %d, want between 3 and 12", name, n)
+			}
+		}
+	}
+	if generated < 190 {
+		t.Errorf("got only %d values out of 200", generated)
+	}
+
+	// The lengths are bounded by the input left before allocating.
+	c := gofuzzheaders.NewConsumer([]byte{0x00, 'a', 'b'})
+	s := struct {
+		B []byte `fuzz:"minlen=1,maxlen=10000000"`
+	}{}
+	if err := c.GenerateStruct(&s); err != nil || len(s.B) > 2 {
+		t.Errorf("got %d bytes, %v, want at most the 2 bytes left", len(s.B), err)
+	}
+	c = gofuzzheaders.NewConsumer([]byte{0x00, 'a', 'b'})
+	s2 := struct {
+		S string `fuzz:"minlen=5"`
+	}{}
+	if err := c.GenerateStruct(&s2); !errors.Is(err, bytesource.ErrNotEnoughBytes) {
+		t.Errorf("expected ErrNotEnoughBytes for a minimum longer than the input, got %v", err)
+	}
+}
+
+func TestLengthBoundsLargeMaxLen(t *testing.T) {
+	input := []byte{0xff, 0xff, 0xff, 0x7f}
+	c := gofuzzheaders.NewConsumer(input)
+	s := struct {
+		S []struct{} `fuzz:"maxlen=2000000000"`
+	}{}
+	if err := c.GenerateStruct(&s); err != nil || len(s.S) > len(input) {
+		t.Errorf("got %d elements, %v, want at most the %d bytes left", len(s.S), err, len(input))
+	}
+
+	c = gofuzzheaders.NewConsumer(input)
+	s2 := struct {
+		I []int64 `fuzz:"maxlen=2000000000"`
+	}{}
+	if err := c.GenerateStruct(&s2); len(s2.I) > len(input) {
+		t.Errorf("got %d elements, %v, want at most the %d bytes left", len(s2.I), err, len(input))
+	}
+}
+
+func TestLengthBoundsInvalid(t *testing.T) {
+	for _, target := range []interface{}{
+		&struct {
+			S string `fuzz:"minlen=5,maxlen=2"`
+		}{},
+		&struct {
+			S string `fuzz:"minlen=-1"`
+		}{},
+		&struct {
+			S []int `fuzz:"maxlen=x"`
+		}{},
+		&struct {
+			I int `fuzz:"maxlen=2"`
+		}{},
+	} {
+		c := gofuzzheaders.NewConsumer(make([]byte, 64))
+		if err := c.GenerateStruct(target); err == nil {
+			t.Errorf("expected an error for %T", target)
+		}
+	}
+}
+
+func TestScript(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		input := make([]byte, 256)
+		r.Read(input)
+
+		c := gofuzzheaders.NewConsumer(input)
+		s := struct {
+			Cyrillic string `fuzz:"script=cyrillic"`
+			Emoji    string `fuzz:"script=emoji"`
+		}{}
+
+		generate(t, c, &s)
+
+		for _, r := range s.Cyrillic {
+			if r < 0x0400 || r > 0x04FF {
+				t.Errorf("rune %U is not cyrillic", r)
+			}
+		}
+		for _, r := range s.Emoji {
+			if r < 0x1F300 || r > 0x1F64F {
+				t.Errorf("rune %U is not an emoji", r)
+			}
+		}
+	}
+}
+
+func TestOneOfInvalidRate(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	const runs = 2000
+	invalid := 0
+	for i := 0; i < runs; i++ {
+		input := make([]byte, 16)
+		r.Read(input)
+
+		c := gofuzzheaders.NewConsumer(input)
+		s := struct {
+			V int `fuzz:"oneof=1|2|3,invalidrate=0.1"`
+		}{}
+
+		generate(t, c, &s)
+
+		if s.V < 1 || s.V > 3 {
+			invalid++
+		}
+	}
+
+	if rate := float64(invalid) / runs; rate < 0.05 || rate > 0.15 {
+		t.Errorf("got invalid rate %.3f, want about 0.1", rate)
+	}
+}
+
+func TestOneOfWithoutInvalidRate(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		c := gofuzzheaders.NewConsumer([]byte{byte(i)})
+		s := struct {
+			V uint16 `fuzz:"oneof=10|20|30"`
+		}{}
+
+		generate(t, c, &s)
+
+		if s.V != 10 && s.V != 20 && s.V != 30 {
+			t.Fatalf("got %d, want one of 10, 20 or 30", s.V)
+		}
+	}
+}
+
+func TestOneOfInvalidComparesTypedValues(t *testing.T) {
+	c := gofuzzheaders.NewConsumer([]byte{0x00, 0x01})
+	s := struct {
+		V int `fuzz:"oneof=01|2,invalidrate=1"`
+	}{}
+	if err := c.GenerateStruct(&s); err != nil {
+		t.Fatalf("failed to generate struct: %v", err)
+	}
+	if s.V != 3 {
+		t.Errorf("got %d, want 3, the first value after the choices 1 and 2", s.V)
+	}
+}
+
+func TestOneOfInvalidEveryValue(t *testing.T) {
+	values := make([]string, 256)
+	for i := range values {
+		values[i] = strconv.Itoa(i)
+	}
+	tag := fmt.Sprintf(`fuzz:"oneof=%s,invalidrate=1"`, strings.Join(values, "|"))
+	typ := reflect.StructOf([]reflect.StructField{{
+		Name: "V",
+		Type: reflect.TypeOf(uint8(0)),
+		Tag:  reflect.StructTag(tag),
+	}})
+
+	c := gofuzzheaders.NewConsumer([]byte{0x00, 0x05})
+	if err := c.GenerateStruct(reflect.New(typ).Interface()); err == nil {
+		t.Errorf("expected an error when the choices cover every value")
+	}
+}
+
+func TestJSONBytes(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	generated := 0
+	for i := 0; i < 200; i++ {
+		input := make([]byte, 256)
+		r.Read(input)
+
+		c := gofuzzheaders.NewConsumer(input)
+		s := struct {
+			Doc []byte `fuzz:"jsonbytes"`
+		}{}
+
+		if !tryGenerate(t, c, &s) {
+			continue
+		}
+
+		if !json.Valid(s.Doc) {
+			t.Errorf("%q is not valid json", s.Doc)
+		}
+		generated++
+	}
+	if generated == 0 {
+		t.Errorf("no json document was generated")
+	}
+}
+
+func TestLanguageTag(t *testing.T) {
+	re := regexp.MustCompile(`^[a-z]{2,3}(-[A-Z][a-z]{3})?(-([A-Z]{2}|[0-9]{3}))?$`)
+	for i := 0; i < 256; i++ {
+		c := gofuzzheaders.NewConsumer([]byte{byte(i), byte(i * 7), byte(i * 13), byte(i * 31)})
+		s := struct {
+			Lang string `fuzz:"langtag"`
+		}{}
+		if !tryGenerate(t, c, &s) {
+			continue
+		}
+
+		if !re.MatchString(s.Lang) {
+			t.Errorf("%q is not a language tag", s.Lang)
+		}
+	}
+}
+
+func TestCRC32(t *testing.T) {
+	type frame struct {
+		Header uint16
+		Body   []byte
+		Sum    uint32 `fuzz:"crc32=over:Header,Body"`
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		input := make([]byte, 256)
+		r.Read(input)
+
+		c := gofuzzheaders.NewConsumer(input)
+		var s frame
+		if !tryGenerate(t, c, &s) {
+			continue
+		}
+
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.BigEndian, s.Header)
+		buf.Write(s.Body)
+		if want := crc32.ChecksumIEEE(buf.Bytes()); s.Sum != want {
+			t.Fatalf("got checksum %#x, want %#x", s.Sum, want)
+		}
+	}
+}
+
+func TestOneOfSpaceSeparated(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 256; i++ {
+		c := gofuzzheaders.NewConsumer([]byte{byte(i), 0x03, 'r', 'a', 'w'})
+		s := struct {
+			Method string `fuzz:"oneof=GET POST PUT DELETE"`
+			Raw    string
+		}{}
+		if err := c.GenerateStruct(&s); err != nil {
+			t.Fatalf("failed to generate struct: %v", err)
+		}
+
+		switch s.Method {
+		case "GET", "POST", "PUT", "DELETE":
+			seen[s.Method] = true
+		default:
+			t.Fatalf("got %q, want one of the listed methods", s.Method)
+		}
+		if s.Raw != "raw" {
+			t.Fatalf("expected the untagged field to be fuzzed, got %q", s.Raw)
+		}
+	}
+	if len(seen) != 4 {
+		t.Errorf("expected every listed method, got %v", seen)
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2023 The go-fuzz-headers Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gofuzzheaders
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type unexportedInner struct {
+	A int
+}
+
+type unexportedOuter struct {
+	unexportedInner
+	b int
+}
+
+// Values that are not addressable can't be reached through the public API,
+// which always generates into a pointer. They must fail cleanly rather than
+// panic in UnsafeAddr.
+func TestUnexportedNotAddressable(t *testing.T) {
+	v := reflect.ValueOf(unexportedOuter{})
+	for _, e := range []reflect.Value{v.Field(0), v.Field(1)} {
+		f := NewConsumer([]byte{0x01, 0x02, 0x03}, WithUnexportedFieldStrategy(KeepFuzzing))
+		err := f.fuzzStruct(e)
+		if err == nil || !strings.Contains(err.Error(), "not addressable") {
+			t.Errorf("got error %v for %s, want a not addressable error", err, e.Type())
+		}
+	}
+
+	// So do the fields of a struct that is not addressable, embedded or not.
+	f := NewConsumer([]byte{0x01, 0x02, 0x03}, WithUnexportedFieldStrategy(KeepFuzzing))
+	if err := f.fuzzFields(v); err == nil || !strings.Contains(err.Error(), "not addressable") {
+		t.Errorf("got error %v, want a not addressable error", err)
+	}
+}